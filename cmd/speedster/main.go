@@ -2,17 +2,37 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/thiemok/speedster/pkg/metrics"
+	"github.com/thiemok/speedster/pkg/notify"
 	"github.com/thiemok/speedster/pkg/speedtest"
 )
 
+// exitThresholdFailed is the exit code of a run that missed a failure threshold,
+// telling a connection below the SLA apart from a run that failed (exit code 1)
+const exitThresholdFailed = 2
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "quarantine":
+			runQuarantine()
+		case "server":
+			runServer()
+		default:
+			log.Fatalf("Unknown command: %s", os.Args[1])
+		}
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -31,67 +51,116 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize OTEL: %v", err)
 	}
-	defer func() {
+	shutdownOTEL := func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		if err := shutdown(ctx); err != nil {
 			log.Printf("Error during OTEL shutdown: %v", err)
 		}
-	}()
+	}
+	defer shutdownOTEL()
 
 	// Load configuration
 	config := speedtest.LoadConfig()
 	log.Printf("Starting speed test with config: %+v", config)
 
+	// Set up notifications before the run, so misconfigured ones fail early
+	notifyConfig, err := notify.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load notification config: %v", err)
+	}
+	dispatcher, err := notify.New(notifyConfig)
+	if err != nil {
+		log.Fatalf("Failed to create notifiers: %v", err)
+	}
+
 	// Run speed test with tracing
-	runner := speedtest.NewRunner(config)
+	runner, err := speedtest.NewRunner(config)
+	if err != nil {
+		log.Fatalf("Failed to create runner: %v", err)
+	}
 	results, err := runner.Run(ctx)
 	if err != nil {
+		sendNotification(ctx, dispatcher, notify.NewFailureEvent(err))
 		log.Fatalf("Speed test failed: %v", err)
 	}
+	evaluation := config.Evaluate(results)
 
 	// Log individual results
 	log.Printf("Speed test completed successfully with %d measurement(s):", len(results))
 	for _, result := range results {
-		log.Printf("Measurement %d:", result.MeasurementIndex)
-		log.Printf("  Server: %s (%s) - ID: %d", result.Server.Name, result.Server.Country, result.Server.ID)
+		log.Printf("Measurement %d (%s):", result.MeasurementIndex, resultSource(result))
+		log.Printf("  Server: %s (%s) - ID: %s", result.Server.Name, result.Server.Country, result.Server.ID)
+		if result.ServerReused {
+			log.Printf("  Server reused: not enough distinct servers available")
+		}
+		log.Printf("  Repetition: %d", result.Repetition)
+		if result.Client.Known() {
+			log.Printf("  Client: %s", clientSummary(result.Client))
+		}
+		if result.ISPChanged {
+			log.Printf("  ISP changed since the previous run")
+		}
 		log.Printf("  Download: %.2f Mbps", result.DownloadMbps)
+		if result.DownloadTTFB > 0 {
+			log.Printf("  Download TTFB: %v", result.DownloadTTFB)
+		}
 		log.Printf("  Upload: %.2f Mbps", result.UploadMbps)
 		log.Printf("  Latency: %d ms", result.Latency.Milliseconds())
 		log.Printf("  Jitter: %d ms", result.Jitter.Milliseconds())
+		if result.DownloadLoadedLatency > 0 || result.UploadLoadedLatency > 0 {
+			log.Printf("  Loaded latency: %d ms (download), %d ms (upload)",
+				result.DownloadLoadedLatency.Milliseconds(), result.UploadLoadedLatency.Milliseconds())
+		}
+		if result.DownloadSingleStreamMbps > 0 {
+			log.Printf("  Download single stream: %.2f Mbps (%s of multi-stream)",
+				result.DownloadSingleStreamMbps, streamShare(result.DownloadSingleStreamMbps, result.DownloadMbps))
+		}
+		if result.UploadSingleStreamMbps > 0 {
+			log.Printf("  Upload single stream: %.2f Mbps (%s of multi-stream)",
+				result.UploadSingleStreamMbps, streamShare(result.UploadSingleStreamMbps, result.UploadMbps))
+		}
+		if result.PacketLossMeasured {
+			log.Printf("  Packet loss: %.2f%%", result.PacketLoss)
+		}
 		log.Printf("  Duration: %v", result.Duration)
+		logEvaluation("  Verdict", result.Evaluation)
+		logPathTrace(result.PathTrace)
 	}
 
+	logDNSResults(runner.DNSResults())
+	logHTTPTimings(runner.HTTPTimings())
+
 	// Calculate and log statistics if multiple measurements
 	if len(results) > 1 {
-		var totalDownload, totalUpload float64
-		minDownload, maxDownload := results[0].DownloadMbps, results[0].DownloadMbps
-		minUpload, maxUpload := results[0].UploadMbps, results[0].UploadMbps
+		logStatistics(fmt.Sprintf("Statistics across %d measurements:", len(results)), results)
 
+		// Group results per server when servers were measured repeatedly
+		var serverKeys []string
+		byServer := make(map[string][]*speedtest.Result)
 		for _, result := range results {
-			totalDownload += result.DownloadMbps
-			totalUpload += result.UploadMbps
-
-			if result.DownloadMbps < minDownload {
-				minDownload = result.DownloadMbps
-			}
-			if result.DownloadMbps > maxDownload {
-				maxDownload = result.DownloadMbps
+			key := resultSource(result) + "/" + result.Server.ID
+			if _, ok := byServer[key]; !ok {
+				serverKeys = append(serverKeys, key)
 			}
-			if result.UploadMbps < minUpload {
-				minUpload = result.UploadMbps
-			}
-			if result.UploadMbps > maxUpload {
-				maxUpload = result.UploadMbps
+			byServer[key] = append(byServer[key], result)
+		}
+		if len(serverKeys) > 1 && len(serverKeys) < len(results) {
+			for _, key := range serverKeys {
+				group := byServer[key]
+				logStatistics(fmt.Sprintf("Statistics for server %s (%s) across %d measurements:", group[0].Server.Name, key, len(group)), group)
 			}
 		}
 
-		avgDownload := totalDownload / float64(len(results))
-		avgUpload := totalUpload / float64(len(results))
+		logBackendComparison(results)
+	}
 
-		log.Printf("Statistics across %d measurements:", len(results))
-		log.Printf("  Download - Avg: %.2f Mbps, Min: %.2f Mbps, Max: %.2f Mbps", avgDownload, minDownload, maxDownload)
-		log.Printf("  Upload   - Avg: %.2f Mbps, Min: %.2f Mbps, Max: %.2f Mbps", avgUpload, minUpload, maxUpload)
+	if len(config.Thresholds) > 0 {
+		header := "Verdict"
+		if len(results) > 1 {
+			header = fmt.Sprintf("Overall verdict (median of %d measurements)", len(results))
+		}
+		logEvaluation(header, evaluation)
 	}
 
 	// Record metrics for each result
@@ -101,5 +170,224 @@ func main() {
 		}
 	}
 
+	metrics.RecordDNSMetrics(ctx, runner.DNSResults())
+	metrics.RecordHTTPTimingMetrics(ctx, runner.HTTPTimings())
+	metrics.RecordQuarantineMetrics(ctx, runner.Quarantine().Entries(time.Now()))
+	metrics.RecordThresholdMetrics(ctx, evaluation)
+
+	sendNotification(ctx, dispatcher, notify.NewResultEvent(results, evaluation))
+
+	if evaluation.Verdict == speedtest.VerdictFail {
+		log.Println("Speed test completed below the failure thresholds, exiting...")
+		// os.Exit skips deferred calls, flush the metrics first
+		shutdownOTEL()
+		os.Exit(exitThresholdFailed)
+	}
+
 	log.Println("Speed test completed, exiting...")
 }
+
+// resultSource names the backend of the result, and the interface, IP family and proxy it measured through if set
+func resultSource(result *speedtest.Result) string {
+	return result.Backend + networkSource(result.Interface, result.IPFamily, result.Proxy)
+}
+
+// networkSource names the interface, IP family and proxy a measurement went through, empty if unset
+func networkSource(iface, ipFamily, proxy string) string {
+	var source string
+	if iface != "" {
+		source += "@" + iface
+	}
+	if ipFamily != "" {
+		source += "/" + ipFamily
+	}
+	if proxy != "" {
+		source += " via " + proxy
+	}
+	return source
+}
+
+// logDNSResults logs the resolution time of every hostname per resolver
+func logDNSResults(results []speedtest.DNSResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	log.Printf("DNS resolution:")
+	for _, result := range results {
+		if result.Error != "" {
+			log.Printf("  %s%s - %s: failed after %v: %s", result.Resolver, networkSource(result.Interface, result.IPFamily, result.Proxy), result.Hostname, result.Duration.Round(time.Millisecond), result.Error)
+			continue
+		}
+		log.Printf("  %s%s - %s: %v (%d addresses)", result.Resolver, networkSource(result.Interface, result.IPFamily, result.Proxy), result.Hostname, result.Duration.Round(time.Microsecond), result.Addresses)
+	}
+}
+
+// logHTTPTimings logs the stages of every request to a timing target
+func logHTTPTimings(timings []speedtest.HTTPTiming) {
+	if len(timings) == 0 {
+		return
+	}
+
+	log.Printf("HTTP timing:")
+	for _, timing := range timings {
+		if timing.Error != "" {
+			log.Printf("  %s%s #%d: failed after %v: %s", timing.Target, networkSource(timing.Interface, timing.IPFamily, timing.Proxy), timing.Sample, timing.Total.Round(time.Millisecond), timing.Error)
+			continue
+		}
+		log.Printf("  %s%s #%d: DNS %v, connect %v, TLS %v, TTFB %v, total %v", timing.Target, networkSource(timing.Interface, timing.IPFamily, timing.Proxy), timing.Sample,
+			timing.DNS.Round(time.Microsecond), timing.Connect.Round(time.Microsecond), timing.TLS.Round(time.Microsecond),
+			timing.TTFB.Round(time.Microsecond), timing.Total.Round(time.Microsecond))
+	}
+}
+
+// sendNotification sends the event to the configured notifiers, failed
+// notifications are logged but never change the outcome of the run
+func sendNotification(ctx context.Context, dispatcher *notify.Dispatcher, event notify.Event) {
+	if !dispatcher.Enabled() {
+		return
+	}
+	err := dispatcher.Notify(ctx, event)
+	switch {
+	case errors.Is(err, notify.ErrRateLimited):
+		log.Printf("Skipping notification: %v", err)
+	case err != nil:
+		log.Printf("Warning: Failed to send notification: %v", err)
+	}
+}
+
+// logEvaluation logs the threshold verdict and every missed threshold
+func logEvaluation(header string, evaluation speedtest.Evaluation) {
+	if len(evaluation.Checks) == 0 {
+		return
+	}
+
+	// Missed thresholds are indented below the header
+	indent := header[:len(header)-len(strings.TrimLeft(header, " "))] + "  "
+	log.Printf("%s: %s", header, strings.ToUpper(string(evaluation.Verdict)))
+	for _, breach := range evaluation.Breaches() {
+		log.Printf("%s%s", indent, breach)
+	}
+}
+
+// logPathTrace logs the hops of a path trace, unanswered hops as "*"
+func logPathTrace(pathTrace *speedtest.PathTrace) {
+	if pathTrace == nil {
+		return
+	}
+
+	reached := "reached"
+	if !pathTrace.Reached {
+		reached = "not reached"
+	}
+	log.Printf("  Path to %s (%s, %s, %s):", pathTrace.Target, pathTrace.Mode, pathTrace.Reason, reached)
+	for _, hop := range pathTrace.Hops {
+		if hop.Address == "" {
+			log.Printf("    %2d  *", hop.TTL)
+			continue
+		}
+		log.Printf("    %2d  %s  %v  (%d/%d lost)", hop.TTL, hop.Address, hop.Latency.Round(time.Microsecond), hop.Lost, hop.Sent)
+	}
+}
+
+// logBackendComparison logs the statistics per backend and interface and how
+// far each deviates from the first one, when several of them were measured
+func logBackendComparison(results []*speedtest.Result) {
+	var backends []string
+	byBackend := make(map[string][]*speedtest.Result)
+	for _, result := range results {
+		source := resultSource(result)
+		if _, ok := byBackend[source]; !ok {
+			backends = append(backends, source)
+		}
+		byBackend[source] = append(byBackend[source], result)
+	}
+	if len(backends) < 2 {
+		return
+	}
+
+	for _, backend := range backends {
+		group := byBackend[backend]
+		logStatistics(fmt.Sprintf("Statistics for backend %s across %d measurements:", backend, len(group)), group)
+	}
+
+	baseline := backends[0]
+	baseDownload, baseUpload := averageThroughput(byBackend[baseline])
+	log.Printf("Backend comparison (relative to %s):", baseline)
+	for _, backend := range backends[1:] {
+		download, upload := averageThroughput(byBackend[backend])
+		log.Printf("  %s - Download: %+.2f Mbps (%s), Upload: %+.2f Mbps (%s)", backend,
+			download-baseDownload, percentDelta(download, baseDownload),
+			upload-baseUpload, percentDelta(upload, baseUpload))
+	}
+}
+
+// averageThroughput returns the average download and upload throughput of the results
+func averageThroughput(results []*speedtest.Result) (float64, float64) {
+	var totalDownload, totalUpload float64
+	for _, result := range results {
+		totalDownload += result.DownloadMbps
+		totalUpload += result.UploadMbps
+	}
+
+	return totalDownload / float64(len(results)), totalUpload / float64(len(results))
+}
+
+// percentDelta formats the relative difference of value to base
+func percentDelta(value, base float64) string {
+	if base == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", (value-base)/base*100)
+}
+
+// clientSummary formats the ISP and ASN of the client, with its IP address if recorded
+func clientSummary(client speedtest.ClientInfo) string {
+	if client.IP == "" {
+		return client.String()
+	}
+	if client.ISP == "" && client.ASN == 0 {
+		return client.IP
+	}
+	return fmt.Sprintf("%s - %s", client.IP, client.String())
+}
+
+// streamShare formats the single-stream throughput as a share of the multi-stream throughput
+func streamShare(single, multi float64) string {
+	if multi == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.0f%%", single/multi*100)
+}
+
+// logStatistics logs average, minimum and maximum throughput of the results
+func logStatistics(header string, results []*speedtest.Result) {
+	var totalDownload, totalUpload float64
+	minDownload, maxDownload := results[0].DownloadMbps, results[0].DownloadMbps
+	minUpload, maxUpload := results[0].UploadMbps, results[0].UploadMbps
+
+	for _, result := range results {
+		totalDownload += result.DownloadMbps
+		totalUpload += result.UploadMbps
+
+		if result.DownloadMbps < minDownload {
+			minDownload = result.DownloadMbps
+		}
+		if result.DownloadMbps > maxDownload {
+			maxDownload = result.DownloadMbps
+		}
+		if result.UploadMbps < minUpload {
+			minUpload = result.UploadMbps
+		}
+		if result.UploadMbps > maxUpload {
+			maxUpload = result.UploadMbps
+		}
+	}
+
+	avgDownload := totalDownload / float64(len(results))
+	avgUpload := totalUpload / float64(len(results))
+
+	log.Print(header)
+	log.Printf("  Download - Avg: %.2f Mbps, Min: %.2f Mbps, Max: %.2f Mbps", avgDownload, minDownload, maxDownload)
+	log.Printf("  Upload   - Avg: %.2f Mbps, Min: %.2f Mbps, Max: %.2f Mbps", avgUpload, minUpload, maxUpload)
+}
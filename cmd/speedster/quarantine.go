@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+// runQuarantine prints the servers that are currently quarantined
+func runQuarantine() {
+	config := speedtest.LoadConfig()
+	if config.StateDir == "" {
+		log.Fatalf("No state directory configured, set SPEEDTEST_STATE_DIR to inspect the quarantine")
+	}
+
+	quarantine, err := speedtest.LoadQuarantine(config.StateDir, config.QuarantineThreshold, config.QuarantineCooldown)
+	if err != nil {
+		log.Fatalf("Failed to load quarantine state: %v", err)
+	}
+
+	now := time.Now()
+	entries := quarantine.Entries(now)
+	if len(entries) == 0 {
+		fmt.Println("No servers are quarantined")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKEND\tSERVER ID\tNAME\tREMAINING\tLAST REASON")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Backend, entry.ServerID, entry.ServerName, entry.QuarantinedUntil.Sub(now).Round(time.Second), entry.LastReason)
+	}
+	w.Flush()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/thiemok/speedster/pkg/server"
+)
+
+// runServer runs the speedster test server until it receives a shutdown signal
+func runServer() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config := server.LoadConfig()
+	srv, err := server.New(config)
+	if err != nil {
+		log.Fatalf("Failed to create test server: %v", err)
+	}
+
+	log.Printf("Starting test server on %s (TLS: %t)", config.ListenAddress, config.TLSCertFile != "")
+	if err := srv.ListenAndServe(ctx); err != nil {
+		log.Fatalf("Test server failed: %v", err)
+	}
+
+	log.Println("Test server stopped")
+}
@@ -25,6 +25,22 @@ var (
 	uploadGauge   metric.Float64Gauge
 	latencyGauge  metric.Int64Gauge
 	jitterGauge   metric.Int64Gauge
+
+	ttfbGauge             metric.Int64Gauge
+	transferDurationGauge metric.Int64Gauge
+	loadedLatencyGauge    metric.Int64Gauge
+	packetLossGauge       metric.Float64Gauge
+	singleStreamGauge     metric.Float64Gauge
+	ispChangedGauge       metric.Int64Gauge
+	thresholdBreachGauge  metric.Int64Gauge
+
+	quarantineGauge metric.Float64Gauge
+
+	dnsResolutionGauge metric.Int64Gauge
+	dnsFailuresGauge   metric.Int64Gauge
+
+	httpTimingHistogram metric.Int64Histogram
+	httpFailuresGauge   metric.Int64Gauge
 )
 
 // InitOTEL initializes OpenTelemetry metrics and tracing
@@ -87,6 +103,113 @@ func InitOTEL(ctx context.Context) (func(context.Context) error, error) {
 		return nil, fmt.Errorf("failed to create jitter gauge: %w", err)
 	}
 
+	ttfbGauge, err = meter.Int64Gauge(
+		"speedtest_download_ttfb_ns",
+		metric.WithDescription("Time to first byte of the download in nanoseconds"),
+		metric.WithUnit("ns"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TTFB gauge: %w", err)
+	}
+
+	transferDurationGauge, err = meter.Int64Gauge(
+		"speedtest_transfer_duration_ns",
+		metric.WithDescription("Total time of a download or upload in nanoseconds"),
+		metric.WithUnit("ns"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer duration gauge: %w", err)
+	}
+
+	loadedLatencyGauge, err = meter.Int64Gauge(
+		"speedtest_loaded_latency_ns",
+		metric.WithDescription("Latency under load during a download or upload in nanoseconds"),
+		metric.WithUnit("ns"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create loaded latency gauge: %w", err)
+	}
+
+	packetLossGauge, err = meter.Float64Gauge(
+		"speedtest_packet_loss_percent",
+		metric.WithDescription("Share of packets lost on the way to the server in percent"),
+		metric.WithUnit("%"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create packet loss gauge: %w", err)
+	}
+
+	singleStreamGauge, err = meter.Float64Gauge(
+		"speedtest_single_stream_mbps",
+		metric.WithDescription("Throughput over a single connection, for comparison with the multi-stream result"),
+		metric.WithUnit("Mbps"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create single stream gauge: %w", err)
+	}
+
+	ispChangedGauge, err = meter.Int64Gauge(
+		"speedtest_isp_changed",
+		metric.WithDescription("Whether the ISP differs from the one detected in the previous run (1) or not (0)"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ISP changed gauge: %w", err)
+	}
+
+	thresholdBreachGauge, err = meter.Int64Gauge(
+		"speedtest_threshold_breach",
+		metric.WithDescription("Threshold verdict of a metric: within thresholds (0), warning (1) or failure (2)"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create threshold breach gauge: %w", err)
+	}
+
+	dnsResolutionGauge, err = meter.Int64Gauge(
+		"speedtest_dns_resolution_ns",
+		metric.WithDescription("Time to resolve the hostname in nanoseconds"),
+		metric.WithUnit("ns"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS resolution gauge: %w", err)
+	}
+
+	dnsFailuresGauge, err = meter.Int64Gauge(
+		"speedtest_dns_failures",
+		metric.WithDescription("Number of hostnames the resolver failed to resolve"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS failures gauge: %w", err)
+	}
+
+	httpTimingHistogram, err = meter.Int64Histogram(
+		"speedtest_http_timing_ns",
+		metric.WithDescription("Duration of the stages of HTTP requests to timing targets in nanoseconds"),
+		metric.WithUnit("ns"),
+		metric.WithExplicitBucketBoundaries(
+			1e6, 2.5e6, 5e6, 10e6, 25e6, 50e6, 100e6, 250e6, 500e6, 1e9, 2.5e9, 5e9, 10e9,
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP timing histogram: %w", err)
+	}
+
+	httpFailuresGauge, err = meter.Int64Gauge(
+		"speedtest_http_timing_failures",
+		metric.WithDescription("Number of failed requests to the timing target"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP timing failures gauge: %w", err)
+	}
+
+	quarantineGauge, err = meter.Float64Gauge(
+		"speedtest_server_quarantine_remaining_seconds",
+		metric.WithDescription("Remaining quarantine cooldown of a server in seconds"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create quarantine gauge: %w", err)
+	}
+
 	// Return combined shutdown function
 	return func(ctx context.Context) error {
 		var errs []error
@@ -161,11 +284,21 @@ func initTracing(ctx context.Context, res *resource.Resource) (func(context.Cont
 // RecordSpeedTestMetrics records the speed test results as metrics
 func RecordSpeedTestMetrics(ctx context.Context, result *speedtest.Result) error {
 	attrs := []attribute.KeyValue{
+		attribute.String("backend", result.Backend),
+		attribute.String("interface", result.Interface),
+		attribute.String("ip_family", result.IPFamily),
+		attribute.String("proxy", result.Proxy),
+		attribute.String("isp", result.Client.ISP),
+		attribute.Int("asn", result.Client.ASN),
 		attribute.String("server_id", result.Server.ID),
 		attribute.String("server_name", result.Server.Name),
 		attribute.String("server_country", result.Server.Country),
 		attribute.Int("measurement_index", result.MeasurementIndex),
 	}
+	// The IP address is only set when SPEEDTEST_RECORD_CLIENT_IP allows recording it
+	if result.Client.IP != "" {
+		attrs = append(attrs, attribute.String("client_ip", result.Client.IP))
+	}
 
 	opts := metric.WithAttributes(attrs...)
 
@@ -174,9 +307,169 @@ func RecordSpeedTestMetrics(ctx context.Context, result *speedtest.Result) error
 	latencyGauge.Record(ctx, result.Latency.Nanoseconds(), opts)
 	jitterGauge.Record(ctx, result.Jitter.Nanoseconds(), opts)
 
+	if result.DownloadTTFB > 0 {
+		ttfbGauge.Record(ctx, result.DownloadTTFB.Nanoseconds(), opts)
+	}
+	if result.DownloadDuration > 0 {
+		transferDurationGauge.Record(ctx, result.DownloadDuration.Nanoseconds(),
+			metric.WithAttributes(append(attrs, attribute.String("direction", "download"))...))
+	}
+	if result.UploadDuration > 0 {
+		transferDurationGauge.Record(ctx, result.UploadDuration.Nanoseconds(),
+			metric.WithAttributes(append(attrs, attribute.String("direction", "upload"))...))
+	}
+	if result.DownloadLoadedLatency > 0 {
+		loadedLatencyGauge.Record(ctx, result.DownloadLoadedLatency.Nanoseconds(),
+			metric.WithAttributes(append(attrs, attribute.String("direction", "download"))...))
+	}
+	if result.UploadLoadedLatency > 0 {
+		loadedLatencyGauge.Record(ctx, result.UploadLoadedLatency.Nanoseconds(),
+			metric.WithAttributes(append(attrs, attribute.String("direction", "upload"))...))
+	}
+	if result.DownloadSingleStreamMbps > 0 {
+		singleStreamGauge.Record(ctx, result.DownloadSingleStreamMbps,
+			metric.WithAttributes(append(attrs, attribute.String("direction", "download"))...))
+	}
+	if result.UploadSingleStreamMbps > 0 {
+		singleStreamGauge.Record(ctx, result.UploadSingleStreamMbps,
+			metric.WithAttributes(append(attrs, attribute.String("direction", "upload"))...))
+	}
+	if result.PacketLossMeasured {
+		packetLossGauge.Record(ctx, result.PacketLoss, opts)
+	}
+	if result.Client.Known() {
+		var changed int64
+		if result.ISPChanged {
+			changed = 1
+		}
+		ispChangedGauge.Record(ctx, changed, opts)
+	}
+	for _, check := range result.Evaluation.Checks {
+		thresholdBreachGauge.Record(ctx, int64(check.Verdict.Severity()), metric.WithAttributes(append(attrs,
+			attribute.String("threshold", string(check.Metric)),
+			attribute.String("scope", "measurement"),
+		)...))
+	}
+
 	return nil
 }
 
+// RecordThresholdMetrics records the overall threshold verdict of the run per metric
+func RecordThresholdMetrics(ctx context.Context, evaluation speedtest.Evaluation) {
+	for _, check := range evaluation.Checks {
+		thresholdBreachGauge.Record(ctx, int64(check.Verdict.Severity()), metric.WithAttributes(
+			attribute.String("threshold", string(check.Metric)),
+			attribute.String("scope", "run"),
+		))
+	}
+}
+
+// RecordDNSMetrics records the resolution time of every resolved hostname and
+// the number of failed resolutions per resolver and network
+func RecordDNSMetrics(ctx context.Context, results []speedtest.DNSResult) {
+	type resolverKey struct {
+		resolver, protocol, iface, family, proxy string
+	}
+	var resolvers []resolverKey
+	failures := make(map[resolverKey]int64)
+	for _, result := range results {
+		key := resolverKey{result.Resolver, string(result.Protocol), result.Interface, result.IPFamily, result.Proxy}
+		if _, ok := failures[key]; !ok {
+			resolvers = append(resolvers, key)
+			failures[key] = 0
+		}
+		if result.Error != "" {
+			failures[key]++
+			continue
+		}
+
+		dnsResolutionGauge.Record(ctx, result.Duration.Nanoseconds(), metric.WithAttributes(
+			attribute.String("resolver", result.Resolver),
+			attribute.String("protocol", string(result.Protocol)),
+			attribute.String("hostname", result.Hostname),
+			attribute.String("interface", result.Interface),
+			attribute.String("ip_family", result.IPFamily),
+			attribute.String("proxy", result.Proxy),
+		))
+	}
+
+	for _, key := range resolvers {
+		dnsFailuresGauge.Record(ctx, failures[key], metric.WithAttributes(
+			attribute.String("resolver", key.resolver),
+			attribute.String("protocol", key.protocol),
+			attribute.String("interface", key.iface),
+			attribute.String("ip_family", key.family),
+			attribute.String("proxy", key.proxy),
+		))
+	}
+}
+
+// RecordHTTPTimingMetrics records the stages of every completed request to a
+// timing target and the number of failed requests per target and network
+func RecordHTTPTimingMetrics(ctx context.Context, timings []speedtest.HTTPTiming) {
+	type targetKey struct {
+		target, iface, family, proxy string
+	}
+	var targets []targetKey
+	failures := make(map[targetKey]int64)
+	for _, timing := range timings {
+		key := targetKey{timing.Target, timing.Interface, timing.IPFamily, timing.Proxy}
+		if _, ok := failures[key]; !ok {
+			targets = append(targets, key)
+			failures[key] = 0
+		}
+		if timing.Error != "" {
+			failures[key]++
+			continue
+		}
+
+		stages := []struct {
+			name     string
+			duration time.Duration
+		}{
+			{"dns", timing.DNS},
+			{"connect", timing.Connect},
+			{"tls", timing.TLS},
+			{"ttfb", timing.TTFB},
+			{"total", timing.Total},
+		}
+		for _, stage := range stages {
+			// Stages the request skipped, e.g. TLS for plain HTTP, are left out
+			if stage.duration == 0 {
+				continue
+			}
+			httpTimingHistogram.Record(ctx, stage.duration.Nanoseconds(), metric.WithAttributes(
+				attribute.String("target", timing.Target),
+				attribute.String("stage", stage.name),
+				attribute.String("interface", timing.Interface),
+				attribute.String("ip_family", timing.IPFamily),
+				attribute.String("proxy", timing.Proxy),
+			))
+		}
+	}
+
+	for _, key := range targets {
+		httpFailuresGauge.Record(ctx, failures[key], metric.WithAttributes(
+			attribute.String("target", key.target),
+			attribute.String("interface", key.iface),
+			attribute.String("ip_family", key.family),
+			attribute.String("proxy", key.proxy),
+		))
+	}
+}
+
+// RecordQuarantineMetrics records the currently quarantined servers as metrics
+func RecordQuarantineMetrics(ctx context.Context, entries []speedtest.QuarantineEntry) {
+	now := time.Now()
+	for _, entry := range entries {
+		quarantineGauge.Record(ctx, entry.QuarantinedUntil.Sub(now).Seconds(), metric.WithAttributes(
+			attribute.String("backend", entry.Backend),
+			attribute.String("server_id", entry.ServerID),
+			attribute.String("server_name", entry.ServerName),
+		))
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ChatType is a chat or push service with a notifier of its own
+type ChatType string
+
+const (
+	// ChatSlack posts to a Slack incoming webhook
+	ChatSlack ChatType = "slack"
+
+	// ChatDiscord posts to a Discord channel webhook
+	ChatDiscord ChatType = "discord"
+
+	// ChatTeams posts an Adaptive Card to a Microsoft Teams workflow webhook
+	ChatTeams ChatType = "teams"
+
+	// ChatNtfy publishes to an ntfy topic
+	ChatNtfy ChatType = "ntfy"
+
+	// ChatGotify pushes to a Gotify server
+	ChatGotify ChatType = "gotify"
+)
+
+// Colors of the verdict severities in Slack, Discord and Teams
+var (
+	slackColors   = []string{"#2eb67d", "#ecb22e", "#e01e5a"}
+	discordColors = []int{0x2eb67d, 0xecb22e, 0xe01e5a}
+	teamsColors   = []string{"Good", "Warning", "Attention"}
+)
+
+// Priorities of the verdict severities in ntfy (1-5) and Gotify (0-10)
+var (
+	ntfyPriorities   = []string{"2", "3", "5"}
+	ntfyTags         = []string{"white_check_mark", "warning", "rotating_light"}
+	gotifyPriorities = []int{2, 5, 8}
+)
+
+// chat sends rendered messages to a chat or push service
+type chat struct {
+	chatType ChatType
+	url      string
+	token    string
+	retries  int
+	renderer *messageRenderer
+	client   *http.Client
+}
+
+// newChat creates the notifier of the chat type for the URL
+func newChat(config Config, chatType ChatType, target, token string, renderer *messageRenderer) *chat {
+	return &chat{
+		chatType: chatType,
+		url:      target,
+		token:    token,
+		retries:  config.Retries,
+		renderer: renderer,
+		client:   &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Name identifies the notifier in errors, without credentials
+func (c *chat) Name() string {
+	return string(c.chatType) + " " + redact(c.url)
+}
+
+// Notify renders the message in the format of the service and posts it
+func (c *chat) Notify(ctx context.Context, event Event) error {
+	msg, err := c.renderer.render(event)
+	if err != nil {
+		return err
+	}
+
+	target, contentType, header, body, err := c.request(msg, event.Time)
+	if err != nil {
+		return err
+	}
+
+	return deliver(ctx, c.retries, func() error {
+		return post(ctx, c.client, target, contentType, header, body)
+	})
+}
+
+// request builds the URL, content type, headers and body the service expects
+func (c *chat) request(msg message, at time.Time) (string, string, http.Header, []byte, error) {
+	header := http.Header{}
+	var body any
+
+	switch c.chatType {
+	case ChatSlack:
+		body = map[string]any{
+			"text": msg.Title,
+			"attachments": []map[string]any{{
+				"color":     slackColors[msg.Severity],
+				"text":      msg.Text,
+				"mrkdwn_in": []string{"text"},
+			}},
+		}
+	case ChatDiscord:
+		body = map[string]any{
+			"embeds": []map[string]any{{
+				"title":       truncate(msg.Title, 256),
+				"description": truncate(msg.Text, 4096),
+				"color":       discordColors[msg.Severity],
+				"timestamp":   at.UTC().Format(time.RFC3339),
+			}},
+		}
+	case ChatTeams:
+		// Adaptive Card text blocks need blank lines to break lines
+		body = map[string]any{
+			"type": "message",
+			"attachments": []map[string]any{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body": []map[string]any{
+						{"type": "TextBlock", "text": msg.Title, "weight": "Bolder", "size": "Medium", "color": teamsColors[msg.Severity], "wrap": true},
+						{"type": "TextBlock", "text": strings.ReplaceAll(msg.Text, "\n", "\n\n"), "wrap": true},
+					},
+				},
+			}},
+		}
+	case ChatNtfy:
+		// ntfy takes the message as plain body and everything else as headers
+		header.Set("Title", strings.Join(strings.Fields(msg.Title), " "))
+		header.Set("Priority", ntfyPriorities[msg.Severity])
+		header.Set("Tags", ntfyTags[msg.Severity])
+		if c.token != "" {
+			header.Set("Authorization", "Bearer "+c.token)
+		}
+		return c.url, "text/plain; charset=utf-8", header, []byte(msg.Text), nil
+	case ChatGotify:
+		header.Set("X-Gotify-Key", c.token)
+		body = map[string]any{
+			"title":    msg.Title,
+			"message":  msg.Text,
+			"priority": gotifyPriorities[msg.Severity],
+		}
+		encoded, err := json.Marshal(body)
+		return strings.TrimSuffix(c.url, "/") + "/message", "application/json", header, encoded, err
+	default:
+		return "", "", nil, nil, fmt.Errorf("unknown chat type %s", c.chatType)
+	}
+
+	encoded, err := json.Marshal(body)
+	return c.url, "application/json", header, encoded, err
+}
+
+// truncate cuts the text to the limit of the service
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,393 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SMTPSecurity is how the connection to the SMTP server is encrypted
+type SMTPSecurity string
+
+const (
+	// SMTPStartTLS upgrades a plain connection with STARTTLS, and fails if the server does not offer it
+	SMTPStartTLS SMTPSecurity = "starttls"
+
+	// SMTPTLS connects with implicit TLS, usually on port 465
+	SMTPTLS SMTPSecurity = "tls"
+
+	// SMTPNone sends unencrypted, only suitable for relays on the local network
+	SMTPNone SMTPSecurity = "none"
+)
+
+// Valid checks if the SMTP security mode is valid
+func (s SMTPSecurity) Valid() bool {
+	switch s {
+	case SMTPStartTLS, SMTPTLS, SMTPNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// DefaultPort is the usual SMTP submission port of the security mode
+func (s SMTPSecurity) DefaultPort() int {
+	switch s {
+	case SMTPTLS:
+		return 465
+	case SMTPNone:
+		return 25
+	default:
+		return 587
+	}
+}
+
+// emailTemplate is the HTML body of emails, the plain text body is built by emailText
+var emailTemplate = template.Must(template.New("email").Funcs(template.FuncMap{
+	"upper": strings.ToUpper,
+	"trend": formatTrend,
+	"loss":  formatLoss,
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; font-size: 14px; color: #1d1c1d;">
+<h2 style="border-left: 6px solid {{ .Color }}; padding-left: 8px;">{{ .Title }}</h2>
+{{- with .Digest }}
+<p>Last 24 hours: {{ .Runs }} runs, {{ .Breaches }} below thresholds, {{ .Failures }} failed. Worst verdict: <b>{{ upper (print .Worst) }}</b></p>
+{{- end }}
+{{- with .Error }}
+<p><b>Error:</b> {{ . }}</p>
+{{- end }}
+{{- if .Breaches }}
+<p><b>Missed thresholds</b></p>
+<ul>
+{{- range .Breaches }}
+<li>{{ .Description }}</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- if .Results }}
+<p><b>{{ if .Digest }}Latest run{{ else }}Results{{ end }}</b> (verdict {{ upper .Verdict }})</p>
+<table cellpadding="4" cellspacing="0" border="1" style="border-collapse: collapse; border-color: #dddddd;">
+<tr style="background: #f4f4f4;"><th>#</th><th>Backend</th><th>Server</th><th>Download</th><th>Upload</th><th>Latency</th><th>Jitter</th><th>Loss</th><th>Verdict</th></tr>
+{{- range .Results }}
+<tr><td>{{ .MeasurementIndex }}</td><td>{{ .Backend }}</td><td>{{ .Server.Name }}</td><td align="right">{{ printf "%.2f" .DownloadMbps }} Mbps</td><td align="right">{{ printf "%.2f" .UploadMbps }} Mbps</td><td align="right">{{ printf "%.1f" .LatencyMs }} ms</td><td align="right">{{ printf "%.1f" .JitterMs }} ms</td><td align="right">{{ loss .PacketLoss }}</td><td>{{ .Verdict }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- with .Trend }}
+<p><b>Trend vs last week</b> ({{ .Runs }} runs)</p>
+<table cellpadding="4" cellspacing="0">
+<tr><td>Download</td><td>{{ trend .Download "Mbps" }}</td></tr>
+<tr><td>Upload</td><td>{{ trend .Upload "Mbps" }}</td></tr>
+<tr><td>Latency</td><td>{{ trend .Latency "ms" }}</td></tr>
+</table>
+{{- end }}
+<p style="color: #888888; font-size: 12px;">speedster on {{ .Host }}</p>
+</body>
+</html>
+`))
+
+// emailData is the content of an email
+type emailData struct {
+	Payload
+	Title  string
+	Color  string
+	Trend  *Trend
+	Digest *Digest
+}
+
+// email sends a summary of the event to the recipients via SMTP
+type email struct {
+	host     string
+	port     int
+	security SMTPSecurity
+	username string
+	password string
+	from     *mail.Address
+	to       []*mail.Address
+	hostname string
+	timeout  time.Duration
+	retries  int
+	renderer *messageRenderer
+}
+
+// newEmail creates the SMTP notifier for the configured recipients
+func newEmail(config Config, renderer *messageRenderer) *email {
+	return &email{
+		host:     config.SMTPHost,
+		port:     config.SMTPPort,
+		security: config.SMTPSecurity,
+		username: config.SMTPUsername,
+		password: config.SMTPPassword,
+		from:     config.SMTPFrom,
+		to:       config.SMTPTo,
+		hostname: config.Hostname,
+		timeout:  config.Timeout,
+		retries:  config.Retries,
+		renderer: renderer,
+	}
+}
+
+// Name identifies the notifier in errors
+func (e *email) Name() string {
+	return "email " + net.JoinHostPort(e.host, strconv.Itoa(e.port))
+}
+
+// Notify composes the summary of the event and sends it, retrying failed deliveries
+func (e *email) Notify(ctx context.Context, event Event) error {
+	data := emailData{
+		Payload: NewPayload(e.hostname, event),
+		Trend:   event.Trend,
+		Digest:  event.Digest,
+	}
+	if event.Digest != nil {
+		data.Title = fmt.Sprintf("Speed test digest for %s: %d runs, %d below thresholds", e.hostname, event.Digest.Runs, event.Digest.Breaches+event.Digest.Failures)
+		data.Color = slackColors[event.Digest.Worst.Severity()]
+	} else {
+		msg, err := e.renderer.render(event)
+		if err != nil {
+			return err
+		}
+		data.Title = msg.Title
+		data.Color = slackColors[msg.Severity]
+	}
+
+	var html bytes.Buffer
+	if err := emailTemplate.Execute(&html, data); err != nil {
+		return fmt.Errorf("failed to render email: %w", err)
+	}
+	message, err := e.compose(data.Title, emailText(data), html.Bytes(), event.Time)
+	if err != nil {
+		return err
+	}
+
+	return deliver(ctx, e.retries, func() error {
+		return e.send(ctx, message)
+	})
+}
+
+// compose builds the MIME message with a plain text and an HTML alternative
+func (e *email) compose(subject string, text, html []byte, at time.Time) ([]byte, error) {
+	var message bytes.Buffer
+	parts := multipart.NewWriter(&message)
+
+	recipients := make([]string, 0, len(e.to))
+	for _, to := range e.to {
+		recipients = append(recipients, to.String())
+	}
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	domain := e.from.Address[strings.LastIndex(e.from.Address, "@")+1:]
+
+	header := []string{
+		"From: " + e.from.String(),
+		"To: " + strings.Join(recipients, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + at.Format(time.RFC1123Z),
+		"Message-ID: <" + hex.EncodeToString(id) + "@" + domain + ">",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=\"" + parts.Boundary() + "\"",
+		// Keeps vacation responders from answering
+		"Auto-Submitted: auto-generated",
+	}
+	message.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+
+	for _, alternative := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {alternative.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to compose email: %w", err)
+		}
+		encoder := quotedprintable.NewWriter(part)
+		if _, err := encoder.Write(alternative.body); err != nil {
+			return nil, fmt.Errorf("failed to compose email: %w", err)
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compose email: %w", err)
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compose email: %w", err)
+	}
+
+	return message.Bytes(), nil
+}
+
+// send delivers the message once, within the notification timeout
+func (e *email) send(ctx context.Context, message []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	tlsConfig := &tls.Config{ServerName: e.host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	if e.security == SMTPTLS {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The SMTP conversation has no context, the deadline bounds it instead
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		return smtpError(err)
+	}
+	defer client.Close()
+
+	if e.security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return permanent(errors.New("server does not offer STARTTLS"))
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return smtpError(err)
+		}
+	}
+
+	if e.username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return permanent(errors.New("server does not offer authentication"))
+		}
+		// PlainAuth refuses to send credentials unencrypted to anything but localhost
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			var protoErr *textproto.Error
+			if !errors.As(err, &protoErr) {
+				return permanent(err)
+			}
+			return smtpError(err)
+		}
+	}
+
+	if err := client.Mail(e.from.Address); err != nil {
+		return smtpError(err)
+	}
+	for _, to := range e.to {
+		if err := client.Rcpt(to.Address); err != nil {
+			return smtpError(err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return smtpError(err)
+	}
+
+	return client.Quit()
+}
+
+// smtpError marks permanent rejections (5xx replies) as not worth a retry
+func smtpError(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && protoErr.Code >= 500 {
+		return permanent(err)
+	}
+
+	return err
+}
+
+// emailText renders the plain text alternative of the email
+func emailText(data emailData) []byte {
+	var text bytes.Buffer
+	fmt.Fprintf(&text, "%s\n\n", data.Title)
+
+	if digest := data.Digest; digest != nil {
+		fmt.Fprintf(&text, "Last 24 hours: %d runs, %d below thresholds, %d failed. Worst verdict: %s\n\n",
+			digest.Runs, digest.Breaches, digest.Failures, strings.ToUpper(string(digest.Worst)))
+	}
+	if data.Error != "" {
+		fmt.Fprintf(&text, "Error: %s\n\n", data.Error)
+	}
+	if len(data.Breaches) > 0 {
+		text.WriteString("Missed thresholds:\n")
+		for _, breach := range data.Breaches {
+			fmt.Fprintf(&text, "- %s\n", breach.Description)
+		}
+		text.WriteString("\n")
+	}
+
+	if len(data.Results) > 0 {
+		heading := "Results"
+		if data.Digest != nil {
+			heading = "Latest run"
+		}
+		fmt.Fprintf(&text, "%s (verdict %s):\n", heading, strings.ToUpper(data.Verdict))
+
+		table := tabwriter.NewWriter(&text, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "#\tBackend\tServer\tDownload\tUpload\tLatency\tJitter\tLoss\tVerdict")
+		for _, result := range data.Results {
+			fmt.Fprintf(table, "%d\t%s\t%s\t%.2f Mbps\t%.2f Mbps\t%.1f ms\t%.1f ms\t%s\t%s\n",
+				result.MeasurementIndex, result.Backend, result.Server.Name, result.DownloadMbps, result.UploadMbps,
+				result.LatencyMs, result.JitterMs, formatLoss(result.PacketLoss), result.Verdict)
+		}
+		table.Flush()
+		text.WriteString("\n")
+	}
+
+	if trend := data.Trend; trend != nil {
+		fmt.Fprintf(&text, "Trend vs last week (%d runs):\n", trend.Runs)
+		fmt.Fprintf(&text, "  Download  %s\n", formatTrend(trend.Download, "Mbps"))
+		fmt.Fprintf(&text, "  Upload    %s\n", formatTrend(trend.Upload, "Mbps"))
+		fmt.Fprintf(&text, "  Latency   %s\n\n", formatTrend(trend.Latency, "ms"))
+	}
+
+	fmt.Fprintf(&text, "-- \nspeedster on %s\n", data.Host)
+
+	return text.Bytes()
+}
+
+// formatTrend describes the metric now against last week
+func formatTrend(value TrendValue, unit string) string {
+	if !value.Available() {
+		return "not measured in both periods"
+	}
+
+	return fmt.Sprintf("%.2f %s, last week %.2f %s (%+.1f%%)", value.Current, unit, value.LastWeek, unit, value.Change())
+}
+
+// formatLoss formats the packet loss of backends that measure it
+func formatLoss(loss *float64) string {
+	if loss == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1f%%", *loss)
+}
@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"slices"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+const (
+	// historyFile holds the runs behind trends and digests
+	historyFile = "notify_history.json"
+
+	// trendWindow is the period the current results are compared against
+	trendWindow = 7 * 24 * time.Hour
+
+	// digestWindow is the period a digest summarizes
+	digestWindow = 24 * time.Hour
+
+	// historyRetention keeps a digest period and the week before it
+	historyRetention = digestWindow + trendWindow
+)
+
+// run is the summary of a past run, the medians across its measurements
+type run struct {
+	Time         time.Time `json:"time"`
+	Verdict      string    `json:"verdict"`
+	Failed       bool      `json:"failed,omitempty"`
+	DownloadMbps float64   `json:"download_mbps,omitempty"`
+	UploadMbps   float64   `json:"upload_mbps,omitempty"`
+	LatencyMs    float64   `json:"latency_ms,omitempty"`
+}
+
+// newRun summarizes the event, skipping metrics a measurement did not measure
+func newRun(event Event) run {
+	var download, upload, latency []float64
+	for _, result := range event.Results {
+		if result.DownloadMbps > 0 {
+			download = append(download, result.DownloadMbps)
+		}
+		if result.UploadMbps > 0 {
+			upload = append(upload, result.UploadMbps)
+		}
+		if result.Latency > 0 {
+			latency = append(latency, milliseconds(result.Latency))
+		}
+	}
+
+	return run{
+		Time:         event.Time,
+		Verdict:      string(event.Evaluation.Verdict),
+		Failed:       event.Kind == EventFailure,
+		DownloadMbps: median(download),
+		UploadMbps:   median(upload),
+		LatencyMs:    median(latency),
+	}
+}
+
+// history is the list of past runs, oldest first
+type history []run
+
+// record appends the run and drops runs older than the retention
+func (h history) record(r run) history {
+	cutoff := r.Time.Add(-historyRetention)
+	kept := slices.DeleteFunc(h, func(past run) bool { return past.Time.Before(cutoff) })
+
+	return append(kept, r)
+}
+
+// between returns the runs from start up to and including end
+func (h history) between(start, end time.Time) history {
+	var runs history
+	for _, r := range h {
+		if !r.Time.Before(start) && !r.Time.After(end) {
+			runs = append(runs, r)
+		}
+	}
+
+	return runs
+}
+
+// Trend compares current results with the week before them
+type Trend struct {
+	// Runs is the number of runs of last week the comparison is based on
+	Runs     int
+	Download TrendValue
+	Upload   TrendValue
+	Latency  TrendValue
+}
+
+// TrendValue is the median of a metric now and last week, zero if not measured
+type TrendValue struct {
+	Current  float64
+	LastWeek float64
+}
+
+// Available reports whether both periods measured the metric
+func (v TrendValue) Available() bool {
+	return v.Current > 0 && v.LastWeek > 0
+}
+
+// Change is the relative change against last week in percent
+func (v TrendValue) Change() float64 {
+	if !v.Available() {
+		return 0
+	}
+
+	return (v.Current - v.LastWeek) / v.LastWeek * 100
+}
+
+// trend compares the runs from start to end with the week before start. It
+// returns nil without runs in that week.
+func (h history) trend(start, end time.Time) *Trend {
+	current := h.between(start, end)
+	lastWeek := h.between(start.Add(-trendWindow), start.Add(-time.Nanosecond))
+	if len(lastWeek) == 0 {
+		return nil
+	}
+
+	value := func(metric func(run) float64) TrendValue {
+		return TrendValue{Current: current.median(metric), LastWeek: lastWeek.median(metric)}
+	}
+
+	return &Trend{
+		Runs:     len(lastWeek),
+		Download: value(func(r run) float64 { return r.DownloadMbps }),
+		Upload:   value(func(r run) float64 { return r.UploadMbps }),
+		Latency:  value(func(r run) float64 { return r.LatencyMs }),
+	}
+}
+
+// median returns the median of the metric across the runs that measured it
+func (h history) median(metric func(run) float64) float64 {
+	var values []float64
+	for _, r := range h {
+		if value := metric(r); value > 0 {
+			values = append(values, value)
+		}
+	}
+
+	return median(values)
+}
+
+// Digest summarizes the runs of the last day
+type Digest struct {
+	Since time.Time
+	Runs  int
+	// Breaches counts runs with a warn or fail verdict, Failures runs that failed to measure
+	Breaches int
+	Failures int
+	// Worst is the worst verdict of the period
+	Worst speedtest.Verdict
+}
+
+// digest summarizes the runs from start to end
+func (h history) digest(start, end time.Time) *Digest {
+	digest := &Digest{Since: start, Worst: speedtest.VerdictPass}
+	for _, r := range h.between(start, end) {
+		digest.Runs++
+		verdict := speedtest.Verdict(r.Verdict)
+		switch {
+		case r.Failed:
+			digest.Failures++
+		case verdict.Severity() > 0:
+			digest.Breaches++
+		}
+		if verdict.Severity() > digest.Worst.Severity() {
+			digest.Worst = verdict
+		}
+	}
+
+	return digest
+}
+
+// median returns the median of the values, 0 without values
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{name: "no values", values: nil, want: 0},
+		{name: "single value", values: []float64{42}, want: 42},
+		{name: "odd count", values: []float64{30, 10, 20}, want: 20},
+		{name: "even count", values: []float64{40, 10, 30, 20}, want: 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := slices.Clone(tt.values)
+			if got := median(values); got != tt.want {
+				t.Errorf("got median %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(values, tt.values) {
+				t.Errorf("median reordered its input to %v", values)
+			}
+		})
+	}
+}
+
+func TestHistoryTrend(t *testing.T) {
+	start := time.Date(2026, 6, 10, 8, 0, 0, 0, time.UTC)
+	end := start.Add(digestWindow)
+	runs := history{
+		{Time: start.Add(-trendWindow - time.Second), DownloadMbps: 1000, LatencyMs: 1000},
+		{Time: start.Add(-trendWindow), DownloadMbps: 10, UploadMbps: 5, LatencyMs: 10},
+		{Time: start.Add(-3 * 24 * time.Hour), DownloadMbps: 30, LatencyMs: 30},
+		{Time: start.Add(-time.Nanosecond), DownloadMbps: 20},
+		{Time: start, DownloadMbps: 100, LatencyMs: 15},
+		{Time: end, DownloadMbps: 200, LatencyMs: 25},
+		{Time: end.Add(time.Second), DownloadMbps: 5000, UploadMbps: 5000, LatencyMs: 5000},
+	}
+
+	trend := runs.trend(start, end)
+	if trend == nil {
+		t.Fatal("got no trend, want one against last week")
+	}
+
+	// Last week runs from exactly a week before start up to just before it
+	if trend.Runs != 3 {
+		t.Errorf("got %d runs of last week, want 3", trend.Runs)
+	}
+	tests := []struct {
+		name          string
+		value         TrendValue
+		want          TrendValue
+		wantAvailable bool
+		wantChange    float64
+	}{
+		{name: "download", value: trend.Download, want: TrendValue{Current: 150, LastWeek: 20}, wantAvailable: true, wantChange: 650},
+		{name: "upload only measured last week", value: trend.Upload, want: TrendValue{Current: 0, LastWeek: 5}},
+		{name: "latency skips unmeasured runs", value: trend.Latency, want: TrendValue{Current: 20, LastWeek: 20}, wantAvailable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value != tt.want {
+				t.Errorf("got %+v, want %+v", tt.value, tt.want)
+			}
+			if got := tt.value.Available(); got != tt.wantAvailable {
+				t.Errorf("got available %v, want %v", got, tt.wantAvailable)
+			}
+			if got := tt.value.Change(); got != tt.wantChange {
+				t.Errorf("got change %v%%, want %v%%", got, tt.wantChange)
+			}
+		})
+	}
+}
+
+func TestHistoryTrendWithoutLastWeek(t *testing.T) {
+	start := time.Date(2026, 6, 10, 8, 0, 0, 0, time.UTC)
+	runs := history{
+		{Time: start.Add(-trendWindow - time.Nanosecond), DownloadMbps: 10},
+		{Time: start, DownloadMbps: 100},
+	}
+
+	if trend := runs.trend(start, start); trend != nil {
+		t.Errorf("got trend %+v, want none without runs last week", trend)
+	}
+}
+
+func TestHistoryDigest(t *testing.T) {
+	end := time.Date(2026, 6, 10, 8, 0, 0, 0, time.UTC)
+	start := end.Add(-digestWindow)
+
+	tests := []struct {
+		name string
+		runs history
+		want Digest
+	}{
+		{
+			name: "no runs",
+			want: Digest{Since: start, Worst: speedtest.VerdictPass},
+		},
+		{
+			name: "passing runs",
+			runs: history{
+				{Time: start, Verdict: "pass"},
+				{Time: end, Verdict: "pass"},
+			},
+			want: Digest{Since: start, Runs: 2, Worst: speedtest.VerdictPass},
+		},
+		{
+			name: "breaches and failures",
+			runs: history{
+				{Time: start.Add(-time.Second), Verdict: "fail"},
+				{Time: start, Verdict: "warn"},
+				{Time: start.Add(time.Hour), Verdict: "pass"},
+				{Time: start.Add(2 * time.Hour), Verdict: "fail"},
+				{Time: end, Verdict: "fail", Failed: true},
+				{Time: end.Add(time.Second), Verdict: "fail", Failed: true},
+			},
+			want: Digest{Since: start, Runs: 4, Breaches: 2, Failures: 1, Worst: speedtest.VerdictFail},
+		},
+		{
+			name: "warnings only",
+			runs: history{
+				{Time: start.Add(time.Hour), Verdict: "warn"},
+				{Time: start.Add(2 * time.Hour), Verdict: "pass"},
+			},
+			want: Digest{Since: start, Runs: 2, Breaches: 1, Worst: speedtest.VerdictWarn},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.runs.digest(start, end); *got != tt.want {
+				t.Errorf("got digest %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryRecord(t *testing.T) {
+	now := time.Date(2026, 6, 10, 8, 0, 0, 0, time.UTC)
+	runs := history{
+		{Time: now.Add(-historyRetention - time.Second)},
+		{Time: now.Add(-historyRetention)},
+		{Time: now.Add(-time.Hour)},
+	}
+
+	runs = runs.record(run{Time: now})
+	var times []time.Time
+	for _, r := range runs {
+		times = append(times, r.Time)
+	}
+	want := []time.Time{now.Add(-historyRetention), now.Add(-time.Hour), now}
+	if !slices.Equal(times, want) {
+		t.Errorf("got runs at %v, want %v", times, want)
+	}
+}
+
+func TestNewRun(t *testing.T) {
+	now := time.Date(2026, 6, 10, 8, 0, 0, 0, time.UTC)
+	event := Event{
+		Kind:       EventBreach,
+		Time:       now,
+		Evaluation: speedtest.Evaluation{Verdict: speedtest.VerdictWarn},
+		Results: []*speedtest.Result{
+			{DownloadMbps: 100, UploadMbps: 10, Latency: 20 * time.Millisecond},
+			{DownloadMbps: 300, Latency: 10 * time.Millisecond},
+			{DownloadMbps: 200, UploadMbps: 30, Latency: 30 * time.Millisecond},
+		},
+	}
+
+	// Measurements without upload do not pull the median down
+	want := run{Time: now, Verdict: "warn", DownloadMbps: 200, UploadMbps: 20, LatencyMs: 20}
+	if got := newRun(event); got != want {
+		t.Errorf("got run %+v, want %+v", got, want)
+	}
+}
+
+func TestDigestDue(t *testing.T) {
+	// The digest hour is local time, as configured
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 6, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name       string
+		disabled   bool
+		hour       int
+		lastDigest time.Time
+		now        time.Time
+		want       bool
+	}{
+		{name: "disabled", disabled: true, hour: 8, now: at(10, 9, 0)},
+		{name: "never sent", hour: 8, now: at(10, 9, 0), want: true},
+		{name: "never sent before the hour", hour: 8, now: at(10, 7, 0), want: true},
+		{name: "before the hour, sent yesterday", hour: 8, lastDigest: at(9, 8, 0), now: at(10, 7, 59)},
+		{name: "at the hour, sent yesterday", hour: 8, lastDigest: at(9, 8, 30), now: at(10, 8, 0), want: true},
+		{name: "after the hour, sent today", hour: 8, lastDigest: at(10, 8, 0), now: at(10, 20, 0)},
+		{name: "missed yesterday", hour: 8, lastDigest: at(8, 9, 0), now: at(10, 7, 0), want: true},
+		{name: "midnight, sent yesterday", hour: 0, lastDigest: at(9, 0, 5), now: at(10, 0, 0), want: true},
+		{name: "just before midnight", hour: 0, lastDigest: at(9, 0, 5), now: at(9, 23, 59)},
+		{name: "after midnight, hour not reached", hour: 23, lastDigest: at(9, 23, 10), now: at(10, 0, 30)},
+		{name: "after midnight, yesterday's missed", hour: 23, lastDigest: at(9, 22, 0), now: at(10, 0, 30), want: true},
+		{name: "late hour reached", hour: 23, lastDigest: at(9, 23, 10), now: at(10, 23, 0), want: true},
+		{name: "run time in another zone", hour: 8, lastDigest: at(9, 8, 30), now: at(10, 8, 0).UTC(), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dispatcher{config: Config{SMTPDigest: !tt.disabled, SMTPDigestHour: tt.hour}}
+			if got := d.digestDue(state{LastDigest: tt.lastDigest}, tt.now); got != tt.want {
+				t.Errorf("got due %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
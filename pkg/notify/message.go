@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+// defaultTitleTemplate is the title of chat notifications
+const defaultTitleTemplate = `{{ if eq .Event "failure" }}Speed test failed on {{ .Host }}` +
+	`{{ else if eq .Event "recovery" }}Speed test recovered on {{ .Host }}` +
+	`{{ else }}Speed test {{ upper .Verdict }} on {{ .Host }}{{ end }}`
+
+// defaultMessageTemplate is the text of chat notifications: the error or the
+// missed thresholds, followed by a line per measurement
+const defaultMessageTemplate = `{{ with .Error }}{{ . }}
+{{ end }}{{ range .Breaches }}- {{ .Description }}
+{{ end }}{{ range .Results }}#{{ .MeasurementIndex }} {{ .Backend }} - {{ .Server.Name }}: ` +
+	`{{ printf "%.2f" .DownloadMbps }} / {{ printf "%.2f" .UploadMbps }} Mbps, {{ printf "%.1f" .LatencyMs }} ms` +
+	`{{ with .Verdict }} ({{ . }}){{ end }}
+{{ end }}`
+
+// message is a rendered chat notification
+type message struct {
+	Title string
+	Text  string
+	// Severity is the verdict severity, pass for recoveries
+	Severity int
+}
+
+// messageRenderer renders the title and text of chat notifications
+type messageRenderer struct {
+	host  string
+	title *template.Template
+	text  *template.Template
+}
+
+// newMessageRenderer parses the message templates, the defaults if unset
+func newMessageRenderer(config Config) (*messageRenderer, error) {
+	titleTemplate := config.TitleTemplate
+	if titleTemplate == "" {
+		titleTemplate = defaultTitleTemplate
+	}
+	messageTemplate := config.MessageTemplate
+	if messageTemplate == "" {
+		messageTemplate = defaultMessageTemplate
+	}
+
+	title, err := template.New("title").Funcs(templateFuncs).Parse(titleTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification title template: %w", err)
+	}
+	text, err := template.New("message").Funcs(templateFuncs).Parse(messageTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification message template: %w", err)
+	}
+
+	return &messageRenderer{host: config.Hostname, title: title, text: text}, nil
+}
+
+// render renders the event into a chat message
+func (m *messageRenderer) render(event Event) (message, error) {
+	payload := NewPayload(m.host, event)
+
+	var title, text bytes.Buffer
+	if err := m.title.Execute(&title, payload); err != nil {
+		return message{}, fmt.Errorf("failed to render notification title: %w", err)
+	}
+	if err := m.text.Execute(&text, payload); err != nil {
+		return message{}, fmt.Errorf("failed to render notification message: %w", err)
+	}
+
+	severity := event.Evaluation.Verdict.Severity()
+	if event.Kind == EventRecovery {
+		severity = speedtest.VerdictPass.Severity()
+	}
+
+	return message{
+		Title:    strings.TrimSpace(title.String()),
+		Text:     strings.TrimSpace(text.String()),
+		Severity: severity,
+	}, nil
+}
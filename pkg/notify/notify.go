@@ -0,0 +1,494 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+// EventKind tells what happened in the run a notification reports
+type EventKind string
+
+const (
+	// EventPass is a run that met all thresholds, not notified
+	EventPass EventKind = "pass"
+
+	// EventBreach is a run that missed a threshold
+	EventBreach EventKind = "breach"
+
+	// EventFailure is a run that failed to measure
+	EventFailure EventKind = "failure"
+
+	// EventRecovery is the first passing run after a notified breach or failure
+	EventRecovery EventKind = "recovery"
+
+	// EventDigest is the daily email summary, sent with the first run after the digest hour
+	EventDigest EventKind = "digest"
+)
+
+// stateFile holds the notification state between runs
+const stateFile = "notify.json"
+
+// ErrRateLimited is returned when a notification was skipped to keep a
+// flapping connection from flooding the channels
+var ErrRateLimited = errors.New("notification rate limited")
+
+// Event is the outcome of a run, as delivered to the notifiers
+type Event struct {
+	Kind       EventKind
+	Time       time.Time
+	Evaluation speedtest.Evaluation
+	Results    []*speedtest.Result
+	// Error is the reason a failed run failed
+	Error string
+	// Trend compares the run, or the day of a digest, with last week. It is
+	// only set for emails, which keep the run history.
+	Trend *Trend
+	// Digest summarizes the last day in digest events
+	Digest *Digest
+}
+
+// NewResultEvent creates the event of a completed run from its evaluation
+func NewResultEvent(results []*speedtest.Result, evaluation speedtest.Evaluation) Event {
+	kind := EventBreach
+	if evaluation.Verdict.Severity() == 0 {
+		kind = EventPass
+	}
+
+	return Event{Kind: kind, Time: time.Now(), Evaluation: evaluation, Results: results}
+}
+
+// NewFailureEvent creates the event of a run that failed with the error
+func NewFailureEvent(err error) Event {
+	return Event{
+		Kind:       EventFailure,
+		Time:       time.Now(),
+		Evaluation: speedtest.Evaluation{Verdict: speedtest.VerdictFail},
+		Error:      err.Error(),
+	}
+}
+
+// Notifier delivers events to a destination
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, event Event) error
+}
+
+// Config holds the notification configuration
+type Config struct {
+	// Hostname identifies the reporting instance, the host name by default
+	Hostname         string
+	NotifyOnWarn     bool
+	NotifyOnRecovery bool
+	MinInterval      time.Duration
+	StateDir         string
+	Timeout          time.Duration
+	Retries          int
+	WebhookURLs      []string
+	WebhookSecret    string
+	WebhookTemplate  string
+	ContentType      string
+	TitleTemplate    string
+	MessageTemplate  string
+	SlackURLs        []string
+	DiscordURLs      []string
+	TeamsURLs        []string
+	NtfyURLs         []string
+	NtfyToken        string
+	GotifyURLs       []string
+	GotifyToken      string
+	SMTPHost         string
+	SMTPPort         int
+	SMTPSecurity     SMTPSecurity
+	SMTPUsername     string
+	SMTPPassword     string
+	SMTPFrom         *mail.Address
+	SMTPTo           []*mail.Address
+	// SMTPDigest sends a daily summary with the first run after SMTPDigestHour, in local time
+	SMTPDigest     bool
+	SMTPDigestHour int
+}
+
+// LoadConfig loads configuration from environment variables
+func LoadConfig() (Config, error) {
+	hostname, _ := os.Hostname()
+
+	// Destinations, one notifier per URL
+	urls := make(map[string][]string)
+	for _, key := range []string{
+		"SPEEDTEST_WEBHOOK_URLS", "SPEEDTEST_SLACK_WEBHOOK_URLS", "SPEEDTEST_DISCORD_WEBHOOK_URLS",
+		"SPEEDTEST_TEAMS_WEBHOOK_URLS", "SPEEDTEST_NTFY_URLS", "SPEEDTEST_GOTIFY_URL",
+	} {
+		urls[key] = parseList(getEnv(key, ""))
+		for _, target := range urls[key] {
+			if parsed, err := url.Parse(target); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return Config{}, fmt.Errorf("invalid URL '%s' in %s, expected an http or https URL", redact(target), key)
+			}
+		}
+	}
+	gotifyURLs := urls["SPEEDTEST_GOTIFY_URL"]
+	if len(gotifyURLs) > 1 {
+		return Config{}, errors.New("SPEEDTEST_GOTIFY_URL takes a single server URL")
+	}
+	gotifyToken := getEnv("SPEEDTEST_GOTIFY_TOKEN", "")
+	if len(gotifyURLs) > 0 && gotifyToken == "" {
+		return Config{}, errors.New("SPEEDTEST_GOTIFY_URL requires SPEEDTEST_GOTIFY_TOKEN")
+	}
+
+	// Email needs a sender and recipients, the port follows the security mode
+	smtpHost := getEnv("SPEEDTEST_SMTP_HOST", "")
+	smtpSecurity := SMTPSecurity(strings.ToLower(getEnv("SPEEDTEST_SMTP_SECURITY", string(SMTPStartTLS))))
+	if !smtpSecurity.Valid() {
+		return Config{}, fmt.Errorf("invalid SMTP security '%s', expected starttls, tls or none", smtpSecurity)
+	}
+	var smtpFrom *mail.Address
+	var smtpTo []*mail.Address
+	if smtpHost != "" {
+		from, err := mail.ParseAddress(getEnv("SPEEDTEST_SMTP_FROM", ""))
+		if err != nil {
+			return Config{}, fmt.Errorf("SPEEDTEST_SMTP_HOST requires a valid SPEEDTEST_SMTP_FROM address: %w", err)
+		}
+		smtpFrom = from
+		for _, recipient := range parseList(getEnv("SPEEDTEST_SMTP_TO", "")) {
+			to, err := mail.ParseAddress(recipient)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid address '%s' in SPEEDTEST_SMTP_TO: %w", recipient, err)
+			}
+			smtpTo = append(smtpTo, to)
+		}
+		if len(smtpTo) == 0 {
+			return Config{}, errors.New("SPEEDTEST_SMTP_HOST requires SPEEDTEST_SMTP_TO")
+		}
+	}
+	smtpDigestHour := getEnvInt("SPEEDTEST_SMTP_DIGEST_HOUR", 8)
+	if smtpDigestHour < 0 || smtpDigestHour > 23 {
+		return Config{}, fmt.Errorf("invalid SPEEDTEST_SMTP_DIGEST_HOUR %d, expected 0-23", smtpDigestHour)
+	}
+
+	// A template file takes precedence over an inline template
+	webhookTemplate := getEnv("SPEEDTEST_WEBHOOK_TEMPLATE", "")
+	if path := getEnv("SPEEDTEST_WEBHOOK_TEMPLATE_FILE", ""); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read webhook template: %w", err)
+		}
+		webhookTemplate = string(content)
+	}
+
+	// Recoveries and rate limiting compare against the previous run
+	stateDir := getEnv("SPEEDTEST_STATE_DIR", "")
+	notifyOnRecovery := getEnvBool("SPEEDTEST_NOTIFY_ON_RECOVERY", false)
+	minInterval := getEnvDuration("SPEEDTEST_NOTIFY_MIN_INTERVAL", 0)
+	smtpDigest := smtpHost != "" && getEnvBool("SPEEDTEST_SMTP_DIGEST", false)
+	if stateDir == "" && (notifyOnRecovery || minInterval > 0 || smtpDigest) {
+		fmt.Fprintf(os.Stderr, "Warning: SPEEDTEST_NOTIFY_ON_RECOVERY, SPEEDTEST_NOTIFY_MIN_INTERVAL and SPEEDTEST_SMTP_DIGEST require SPEEDTEST_STATE_DIR, ignoring them\n")
+		notifyOnRecovery = false
+		minInterval = 0
+		smtpDigest = false
+	}
+
+	return Config{
+		Hostname:         getEnv("SPEEDTEST_NOTIFY_HOSTNAME", hostname),
+		NotifyOnWarn:     getEnvBool("SPEEDTEST_NOTIFY_ON_WARN", true),
+		NotifyOnRecovery: notifyOnRecovery,
+		MinInterval:      minInterval,
+		StateDir:         stateDir,
+		Timeout:          getEnvDuration("SPEEDTEST_NOTIFY_TIMEOUT", 10*time.Second),
+		Retries:          max(getEnvInt("SPEEDTEST_NOTIFY_RETRIES", 3), 0),
+		WebhookURLs:      urls["SPEEDTEST_WEBHOOK_URLS"],
+		WebhookSecret:    getEnv("SPEEDTEST_WEBHOOK_SECRET", ""),
+		WebhookTemplate:  webhookTemplate,
+		ContentType:      getEnv("SPEEDTEST_WEBHOOK_CONTENT_TYPE", "application/json"),
+		TitleTemplate:    getEnv("SPEEDTEST_NOTIFY_TITLE_TEMPLATE", ""),
+		MessageTemplate:  getEnv("SPEEDTEST_NOTIFY_MESSAGE_TEMPLATE", ""),
+		SlackURLs:        urls["SPEEDTEST_SLACK_WEBHOOK_URLS"],
+		DiscordURLs:      urls["SPEEDTEST_DISCORD_WEBHOOK_URLS"],
+		TeamsURLs:        urls["SPEEDTEST_TEAMS_WEBHOOK_URLS"],
+		NtfyURLs:         urls["SPEEDTEST_NTFY_URLS"],
+		NtfyToken:        getEnv("SPEEDTEST_NTFY_TOKEN", ""),
+		GotifyURLs:       gotifyURLs,
+		GotifyToken:      gotifyToken,
+		SMTPHost:         smtpHost,
+		SMTPPort:         getEnvInt("SPEEDTEST_SMTP_PORT", smtpSecurity.DefaultPort()),
+		SMTPSecurity:     smtpSecurity,
+		SMTPUsername:     getEnv("SPEEDTEST_SMTP_USERNAME", ""),
+		SMTPPassword:     getEnv("SPEEDTEST_SMTP_PASSWORD", ""),
+		SMTPFrom:         smtpFrom,
+		SMTPTo:           smtpTo,
+		SMTPDigest:       smtpDigest,
+		SMTPDigestHour:   smtpDigestHour,
+	}, nil
+}
+
+// Dispatcher decides which events are worth a notification and sends them to
+// every configured notifier
+type Dispatcher struct {
+	config    Config
+	notifiers []Notifier
+	// email also sends the digests
+	email *email
+}
+
+// New creates the dispatcher with a notifier for every configured destination
+func New(config Config) (*Dispatcher, error) {
+	d := &Dispatcher{config: config}
+
+	for _, target := range config.WebhookURLs {
+		webhook, err := newWebhook(config, target)
+		if err != nil {
+			return nil, err
+		}
+		d.notifiers = append(d.notifiers, webhook)
+	}
+
+	// Chat services share the message templates
+	renderer, err := newMessageRenderer(config)
+	if err != nil {
+		return nil, err
+	}
+	chats := []struct {
+		chatType ChatType
+		urls     []string
+		token    string
+	}{
+		{ChatSlack, config.SlackURLs, ""},
+		{ChatDiscord, config.DiscordURLs, ""},
+		{ChatTeams, config.TeamsURLs, ""},
+		{ChatNtfy, config.NtfyURLs, config.NtfyToken},
+		{ChatGotify, config.GotifyURLs, config.GotifyToken},
+	}
+	for _, c := range chats {
+		for _, target := range c.urls {
+			d.notifiers = append(d.notifiers, newChat(config, c.chatType, target, c.token, renderer))
+		}
+	}
+
+	if config.SMTPHost != "" {
+		d.email = newEmail(config, renderer)
+		d.notifiers = append(d.notifiers, d.email)
+	}
+
+	return d, nil
+}
+
+// Enabled reports whether any notifier is configured
+func (d *Dispatcher) Enabled() bool {
+	return len(d.notifiers) > 0
+}
+
+// state is what the dispatcher remembers between runs
+type state struct {
+	// Alerting is set while the last notification reported a breach or failure
+	Alerting   bool      `json:"alerting"`
+	LastSent   time.Time `json:"last_sent"`
+	LastDigest time.Time `json:"last_digest,omitzero"`
+}
+
+// Notify sends the event to all notifiers if it calls for a notification.
+// Every notifier is tried, the errors of failed ones are joined. Events
+// within SPEEDTEST_NOTIFY_MIN_INTERVAL of the last notification return
+// ErrRateLimited. A due digest is sent regardless of the event.
+func (d *Dispatcher) Notify(ctx context.Context, event Event) error {
+	var last state
+	if d.config.StateDir != "" {
+		if err := speedtest.ReadState(d.config.StateDir, stateFile, &last); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to load notification state, notifying without it: %v\n", err)
+		}
+	}
+
+	// Emails compare with the run history
+	var runs history
+	if d.email != nil && d.config.StateDir != "" {
+		runs = d.recordRun(event)
+		event.Trend = runs.trend(event.Time, event.Time)
+	}
+
+	next, err := d.alert(ctx, last, event)
+
+	if d.digestDue(next, event.Time) {
+		if digestErr := d.sendDigest(ctx, runs, event); digestErr != nil {
+			err = errors.Join(err, fmt.Errorf("%s digest: %w", d.email.Name(), digestErr))
+		} else {
+			next.LastDigest = event.Time
+		}
+	}
+
+	if next != last {
+		d.saveState(next)
+	}
+
+	return err
+}
+
+// alert sends the event to all notifiers if it calls for a notification and
+// returns the state after it
+func (d *Dispatcher) alert(ctx context.Context, last state, event Event) (state, error) {
+	next := last
+
+	problem := d.problem(event)
+	switch {
+	case problem:
+	case last.Alerting && d.config.NotifyOnRecovery:
+		event.Kind = EventRecovery
+	case last.Alerting:
+		// Without recovery notifications the next breach alerts right away
+		next.Alerting = false
+		return next, nil
+	default:
+		return next, nil
+	}
+
+	if since := event.Time.Sub(last.LastSent); d.config.MinInterval > 0 && since < d.config.MinInterval {
+		return next, fmt.Errorf("%w: last notification sent %v ago, minimum interval %v", ErrRateLimited, since.Round(time.Second), d.config.MinInterval)
+	}
+
+	var errs []error
+	for _, notifier := range d.notifiers {
+		if err := notifier.Notify(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", notifier.Name(), err))
+		}
+	}
+
+	// Only a notification that reached somebody changes what was reported
+	if len(errs) < len(d.notifiers) {
+		next.Alerting = problem
+		next.LastSent = event.Time
+	}
+
+	return next, errors.Join(errs...)
+}
+
+// recordRun adds the run to the history and returns the history including it
+func (d *Dispatcher) recordRun(event Event) history {
+	var runs history
+	if err := speedtest.ReadState(d.config.StateDir, historyFile, &runs); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load run history, starting a new one: %v\n", err)
+		runs = nil
+	}
+
+	runs = runs.record(newRun(event))
+	if err := speedtest.WriteState(d.config.StateDir, historyFile, runs); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save run history: %v\n", err)
+	}
+
+	return runs
+}
+
+// digestDue reports whether the daily digest is enabled and not yet sent
+// since the digest hour of the day
+func (d *Dispatcher) digestDue(s state, now time.Time) bool {
+	if !d.config.SMTPDigest {
+		return false
+	}
+
+	now = now.Local()
+	due := time.Date(now.Year(), now.Month(), now.Day(), d.config.SMTPDigestHour, 0, 0, 0, now.Location())
+	if now.Before(due) {
+		due = due.AddDate(0, 0, -1)
+	}
+
+	return s.LastDigest.Before(due)
+}
+
+// sendDigest emails the summary of the last day with the latest run
+func (d *Dispatcher) sendDigest(ctx context.Context, runs history, event Event) error {
+	since := event.Time.Add(-digestWindow)
+	digest := event
+	digest.Kind = EventDigest
+	digest.Digest = runs.digest(since, event.Time)
+	digest.Trend = runs.trend(since, event.Time)
+
+	return d.email.Notify(ctx, digest)
+}
+
+// problem reports whether the event is a breach or failure worth notifying
+func (d *Dispatcher) problem(event Event) bool {
+	switch event.Kind {
+	case EventFailure:
+		return true
+	case EventBreach:
+		return event.Evaluation.Verdict == speedtest.VerdictFail || d.config.NotifyOnWarn
+	default:
+		return false
+	}
+}
+
+// saveState persists the notification state if a state directory is configured
+func (d *Dispatcher) saveState(s state) {
+	if d.config.StateDir == "" {
+		return
+	}
+	if err := speedtest.WriteState(d.config.StateDir, stateFile, s); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save notification state: %v\n", err)
+	}
+}
+
+// redact removes credentials and the query, which often holds a token, from the URL
+func redact(target string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "invalid URL"
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+
+	return parsed.String()
+}
+
+// parseList splits a comma-separated list, dropping empty entries
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(item); trimmed != "" {
+			items = append(items, trimmed)
+		}
+	}
+
+	return items
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		// Try parsing as seconds
+		if i, err := strconv.Atoi(value); err == nil {
+			return time.Duration(i) * time.Second
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
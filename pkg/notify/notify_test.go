@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+// stubNotifier records the events it is sent and fails while err is set
+type stubNotifier struct {
+	name   string
+	err    error
+	events []Event
+}
+
+func (s *stubNotifier) Name() string {
+	return s.name
+}
+
+func (s *stubNotifier) Notify(_ context.Context, event Event) error {
+	s.events = append(s.events, event)
+	return s.err
+}
+
+// testEvent returns an event of the kind at the time
+func testEvent(kind EventKind, at time.Time) Event {
+	verdict := speedtest.VerdictPass
+	if kind != EventPass {
+		verdict = speedtest.VerdictFail
+	}
+
+	return Event{Kind: kind, Time: at, Evaluation: speedtest.Evaluation{Verdict: verdict}}
+}
+
+// readTestState returns the notification state the dispatcher saved
+func readTestState(t *testing.T, dir string) state {
+	t.Helper()
+
+	var saved state
+	if err := speedtest.ReadState(dir, stateFile, &saved); err != nil {
+		t.Fatalf("failed to read notification state: %v", err)
+	}
+
+	return saved
+}
+
+func TestDispatcherRecoveryAndRateLimit(t *testing.T) {
+	dir := t.TempDir()
+	stub := &stubNotifier{name: "stub"}
+	d := &Dispatcher{
+		config:    Config{NotifyOnRecovery: true, MinInterval: time.Hour, StateDir: dir},
+		notifiers: []Notifier{stub},
+	}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		name         string
+		event        Event
+		wantSent     EventKind
+		wantLimited  bool
+		wantAlerting bool
+		wantLastSent time.Time
+	}{
+		{
+			name:         "breach is notified",
+			event:        testEvent(EventBreach, start),
+			wantSent:     EventBreach,
+			wantAlerting: true,
+			wantLastSent: start,
+		},
+		{
+			name:         "breach within the interval is rate limited",
+			event:        testEvent(EventBreach, start.Add(10*time.Minute)),
+			wantLimited:  true,
+			wantAlerting: true,
+			wantLastSent: start,
+		},
+		{
+			name:         "recovery within the interval is rate limited",
+			event:        testEvent(EventPass, start.Add(30*time.Minute)),
+			wantLimited:  true,
+			wantAlerting: true,
+			wantLastSent: start,
+		},
+		{
+			name:         "recovery after the interval is notified",
+			event:        testEvent(EventPass, start.Add(2*time.Hour)),
+			wantSent:     EventRecovery,
+			wantLastSent: start.Add(2 * time.Hour),
+		},
+		{
+			name:         "passing run after the recovery is not notified",
+			event:        testEvent(EventPass, start.Add(4*time.Hour)),
+			wantLastSent: start.Add(2 * time.Hour),
+		},
+		{
+			name:         "failure after the interval is notified",
+			event:        testEvent(EventFailure, start.Add(5*time.Hour)),
+			wantSent:     EventFailure,
+			wantAlerting: true,
+			wantLastSent: start.Add(5 * time.Hour),
+		},
+	}
+
+	for _, step := range steps {
+		sent := len(stub.events)
+		err := d.Notify(context.Background(), step.event)
+
+		if got := errors.Is(err, ErrRateLimited); got != step.wantLimited {
+			t.Fatalf("%s: got error %v, want rate limited %v", step.name, err, step.wantLimited)
+		}
+		if !step.wantLimited && err != nil {
+			t.Fatalf("%s: got error %v", step.name, err)
+		}
+
+		switch {
+		case step.wantSent == "" && len(stub.events) != sent:
+			t.Errorf("%s: got %s notification, want none", step.name, stub.events[sent].Kind)
+		case step.wantSent != "" && len(stub.events) != sent+1:
+			t.Errorf("%s: got %d notifications, want one %s", step.name, len(stub.events)-sent, step.wantSent)
+		case step.wantSent != "" && stub.events[sent].Kind != step.wantSent:
+			t.Errorf("%s: got %s notification, want %s", step.name, stub.events[sent].Kind, step.wantSent)
+		}
+
+		saved := readTestState(t, dir)
+		if saved.Alerting != step.wantAlerting || !saved.LastSent.Equal(step.wantLastSent) {
+			t.Errorf("%s: got state %+v, want alerting %v since %v", step.name, saved, step.wantAlerting, step.wantLastSent)
+		}
+	}
+}
+
+func TestDispatcherWithoutRecovery(t *testing.T) {
+	dir := t.TempDir()
+	stub := &stubNotifier{name: "stub"}
+	d := &Dispatcher{config: Config{StateDir: dir}, notifiers: []Notifier{stub}}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, event := range []Event{
+		testEvent(EventBreach, start),
+		testEvent(EventPass, start.Add(time.Hour)),
+	} {
+		if err := d.Notify(context.Background(), event); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+
+	// The passing run is not notified but ends the alert
+	if len(stub.events) != 1 || stub.events[0].Kind != EventBreach {
+		t.Errorf("got %d notifications, want only the breach", len(stub.events))
+	}
+	if saved := readTestState(t, dir); saved.Alerting {
+		t.Errorf("got state %+v, want the alert ended", saved)
+	}
+}
+
+func TestDispatcherPartialFailure(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		failing      []bool
+		wantAlerting bool
+		wantLastSent time.Time
+	}{
+		{name: "one notifier fails", failing: []bool{true, false}, wantAlerting: true, wantLastSent: start},
+		{name: "every notifier fails", failing: []bool{true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := &Dispatcher{config: Config{NotifyOnRecovery: true, MinInterval: time.Hour, StateDir: dir}}
+			for i, failing := range tt.failing {
+				stub := &stubNotifier{name: fmt.Sprintf("stub-%d", i)}
+				if failing {
+					stub.err = errors.New("unreachable")
+				}
+				d.notifiers = append(d.notifiers, stub)
+			}
+
+			err := d.Notify(context.Background(), testEvent(EventBreach, start))
+			if err == nil || !strings.Contains(err.Error(), "stub-0: unreachable") {
+				t.Fatalf("got error %v, want the failing notifier's error", err)
+			}
+
+			if saved := readTestState(t, dir); saved.Alerting != tt.wantAlerting || !saved.LastSent.Equal(tt.wantLastSent) {
+				t.Errorf("got state %+v, want alerting %v since %v", saved, tt.wantAlerting, tt.wantLastSent)
+			}
+
+			// A notification nobody got is not rate limited on the next run
+			err = d.Notify(context.Background(), testEvent(EventBreach, start.Add(time.Minute)))
+			if got := errors.Is(err, ErrRateLimited); got != tt.wantAlerting {
+				t.Errorf("got error %v on the next run, want rate limited %v", err, tt.wantAlerting)
+			}
+		})
+	}
+}
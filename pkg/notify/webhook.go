@@ -0,0 +1,302 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+const (
+	// retryBaseDelay is the delay before the first retry, doubled for every further one
+	retryBaseDelay = time.Second
+
+	// signatureHeader carries the HMAC-SHA256 of the timestamp and body
+	signatureHeader = "X-Speedster-Signature"
+
+	// timestampHeader carries the signed Unix timestamp, so receivers can reject replays
+	timestampHeader = "X-Speedster-Timestamp"
+)
+
+// Payload is the body of a webhook, and the data of webhook templates
+type Payload struct {
+	Event    EventKind       `json:"event"`
+	Host     string          `json:"host"`
+	Time     time.Time       `json:"time"`
+	Verdict  string          `json:"verdict"`
+	Error    string          `json:"error,omitempty"`
+	Breaches []PayloadCheck  `json:"breaches"`
+	Results  []PayloadResult `json:"results"`
+}
+
+// PayloadCheck is a missed threshold
+type PayloadCheck struct {
+	Metric      string  `json:"metric"`
+	Verdict     string  `json:"verdict"`
+	Value       float64 `json:"value"`
+	Limit       float64 `json:"limit"`
+	Description string  `json:"description"`
+}
+
+// PayloadResult is a single measurement of the run
+type PayloadResult struct {
+	MeasurementIndex int            `json:"measurement_index"`
+	Backend          string         `json:"backend"`
+	Interface        string         `json:"interface,omitempty"`
+	IPFamily         string         `json:"ip_family,omitempty"`
+	Proxy            string         `json:"proxy,omitempty"`
+	ISP              string         `json:"isp,omitempty"`
+	Server           PayloadServer  `json:"server"`
+	DownloadMbps     float64        `json:"download_mbps"`
+	UploadMbps       float64        `json:"upload_mbps"`
+	LatencyMs        float64        `json:"latency_ms"`
+	JitterMs         float64        `json:"jitter_ms"`
+	PacketLoss       *float64       `json:"packet_loss_percent,omitempty"`
+	Verdict          string         `json:"verdict,omitempty"`
+	Breaches         []PayloadCheck `json:"breaches,omitempty"`
+}
+
+// PayloadServer is the test server of a measurement
+type PayloadServer struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Country string `json:"country"`
+}
+
+// NewPayload converts the event into the webhook payload
+func NewPayload(host string, event Event) Payload {
+	payload := Payload{
+		Event:    event.Kind,
+		Host:     host,
+		Time:     event.Time.UTC(),
+		Verdict:  string(event.Evaluation.Verdict),
+		Error:    event.Error,
+		Breaches: payloadChecks(event.Evaluation.Breaches()),
+		Results:  make([]PayloadResult, 0, len(event.Results)),
+	}
+
+	for _, result := range event.Results {
+		entry := PayloadResult{
+			MeasurementIndex: result.MeasurementIndex,
+			Backend:          result.Backend,
+			Interface:        result.Interface,
+			IPFamily:         result.IPFamily,
+			Proxy:            result.Proxy,
+			ISP:              result.Client.ISP,
+			Server:           PayloadServer{ID: result.Server.ID, Name: result.Server.Name, Country: result.Server.Country},
+			DownloadMbps:     result.DownloadMbps,
+			UploadMbps:       result.UploadMbps,
+			LatencyMs:        milliseconds(result.Latency),
+			JitterMs:         milliseconds(result.Jitter),
+			Verdict:          string(result.Evaluation.Verdict),
+			Breaches:         payloadChecks(result.Evaluation.Breaches()),
+		}
+		if result.PacketLossMeasured {
+			entry.PacketLoss = &result.PacketLoss
+		}
+		payload.Results = append(payload.Results, entry)
+	}
+
+	return payload
+}
+
+// payloadChecks converts the missed thresholds into their payload form
+func payloadChecks(checks []speedtest.ThresholdCheck) []PayloadCheck {
+	converted := make([]PayloadCheck, 0, len(checks))
+	for _, check := range checks {
+		converted = append(converted, PayloadCheck{
+			Metric:      string(check.Metric),
+			Verdict:     string(check.Verdict),
+			Value:       check.Value,
+			Limit:       check.Limit,
+			Description: check.String(),
+		})
+	}
+
+	return converted
+}
+
+// milliseconds converts the duration into fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// templateFuncs are the helpers available in webhook templates
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+	"upper": strings.ToUpper,
+}
+
+// webhook POSTs events to an HTTP endpoint
+type webhook struct {
+	url         string
+	secret      string
+	host        string
+	contentType string
+	retries     int
+	template    *template.Template
+	client      *http.Client
+}
+
+// newWebhook creates the webhook notifier for the URL
+func newWebhook(config Config, target string) (*webhook, error) {
+	w := &webhook{
+		url:         target,
+		secret:      config.WebhookSecret,
+		host:        config.Hostname,
+		contentType: config.ContentType,
+		retries:     config.Retries,
+		client:      &http.Client{Timeout: config.Timeout},
+	}
+
+	if config.WebhookTemplate != "" {
+		tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(config.WebhookTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: %w", err)
+		}
+		w.template = tmpl
+	}
+
+	return w, nil
+}
+
+// Name identifies the webhook in errors, without credentials
+func (w *webhook) Name() string {
+	return "webhook " + redact(w.url)
+}
+
+// Notify renders the payload and posts it, retrying failed deliveries
+func (w *webhook) Notify(ctx context.Context, event Event) error {
+	body, err := w.render(NewPayload(w.host, event))
+	if err != nil {
+		return err
+	}
+
+	return deliver(ctx, w.retries, func() error {
+		// Sign every attempt with its own timestamp
+		header := http.Header{}
+		if w.secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			header.Set(timestampHeader, timestamp)
+			header.Set(signatureHeader, "sha256="+sign(w.secret, timestamp, body))
+		}
+		return post(ctx, w.client, w.url, w.contentType, header, body)
+	})
+}
+
+// render renders the payload with the template, as JSON without one
+func (w *webhook) render(payload Payload) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(payload)
+	}
+
+	var body bytes.Buffer
+	if err := w.template.Execute(&body, payload); err != nil {
+		return nil, fmt.Errorf("failed to render webhook template: %w", err)
+	}
+
+	return body.Bytes(), nil
+}
+
+// post sends the body once with the additional headers
+func post(ctx context.Context, client *http.Client, target, contentType string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "speedster")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Transport errors quote the URL, which may carry a token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redact(urlErr.URL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	return checkStatus(resp)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>"
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkStatus turns error statuses into errors. Client errors other than rate
+// limiting will not go away by retrying.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	err := fmt.Errorf("delivery failed with status %s", resp.Status)
+	if resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests {
+		return permanent(err)
+	}
+
+	return err
+}
+
+// permanentError is a delivery error retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// permanent marks the error as not worth a retry
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// deliver calls send until it succeeds, up to retries more times with
+// exponential backoff, and returns the last error
+func deliver(ctx context.Context, retries int, send func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if errors.As(err, &permanentError{}) || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
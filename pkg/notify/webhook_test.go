@@ -0,0 +1,265 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/speedtest"
+)
+
+// webhookReceiver is a local webhook endpoint answering with the given
+// statuses in turn, repeating the last one, and recording every request
+type webhookReceiver struct {
+	server   *httptest.Server
+	statuses []int
+
+	mu       sync.Mutex
+	requests []receivedRequest
+}
+
+// receivedRequest is a request the receiver got
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// newWebhookReceiver starts the receiver, stopped with the test
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	t.Helper()
+
+	r := &webhookReceiver{statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		r.requests = append(r.requests, receivedRequest{header: req.Header.Clone(), body: body})
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status = r.statuses[min(len(r.requests), len(r.statuses))-1]
+		}
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+// received returns the requests received so far
+func (r *webhookReceiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+// testWebhook creates a webhook notifier posting to the receiver
+func testWebhook(t *testing.T, receiver *webhookReceiver, config Config) *webhook {
+	t.Helper()
+
+	config.Hostname = "test-host"
+	config.Timeout = 5 * time.Second
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+	w, err := newWebhook(config, receiver.server.URL)
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	return w
+}
+
+// breachEvent returns a run that missed the download threshold
+func breachEvent() Event {
+	check := speedtest.ThresholdCheck{Metric: speedtest.ThresholdDownload, Verdict: speedtest.VerdictFail, Value: 42, Limit: 50}
+	evaluation := speedtest.Evaluation{Verdict: speedtest.VerdictFail, Checks: []speedtest.ThresholdCheck{check}}
+	result := &speedtest.Result{
+		Backend:      "ookla",
+		Server:       speedtest.ServerInfo{ID: "1234", Name: "Example", Country: "Germany"},
+		DownloadMbps: 42,
+		UploadMbps:   20,
+		Latency:      12 * time.Millisecond,
+		Evaluation:   evaluation,
+	}
+
+	return NewResultEvent([]*speedtest.Result{result}, evaluation)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "delivered", statuses: []int{http.StatusOK}, wantAttempts: 1},
+		{name: "server error is retried", statuses: []int{http.StatusBadGateway, http.StatusOK}, wantAttempts: 2},
+		{name: "rate limit is retried", statuses: []int{http.StatusTooManyRequests, http.StatusNoContent}, wantAttempts: 2},
+		{name: "retries run out", statuses: []int{http.StatusServiceUnavailable}, wantAttempts: 2, wantErr: true},
+		{name: "client error is not retried", statuses: []int{http.StatusBadRequest}, wantAttempts: 1, wantErr: true},
+		{name: "unauthorized is not retried", statuses: []int{http.StatusUnauthorized}, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := newWebhookReceiver(t, tt.statuses...)
+			w := testWebhook(t, receiver, Config{Retries: 1})
+
+			err := w.Notify(context.Background(), breachEvent())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got := len(receiver.received()); got != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	w := testWebhook(t, receiver, Config{WebhookSecret: "s3cret"})
+
+	before := time.Now().Unix()
+	if err := w.Notify(context.Background(), breachEvent()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+
+	timestamp := request.header.Get(timestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || unix < before || unix > time.Now().Unix() {
+		t.Fatalf("got timestamp %q, want the Unix time of the delivery", timestamp)
+	}
+
+	// Receivers verify the HMAC-SHA256 of "<timestamp>.<body>"
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(request.body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := request.header.Get(signatureHeader); got != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+	if got := "sha256=" + sign("s3cret", timestamp, request.body); got != want {
+		t.Errorf("sign returned %q, want %q", got, want)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(request.body, &payload); err != nil {
+		t.Fatalf("body is no JSON payload: %v", err)
+	}
+	if payload.Event != EventBreach || payload.Host != "test-host" || len(payload.Breaches) != 1 || len(payload.Results) != 1 {
+		t.Errorf("got payload %+v, want a breach of test-host with one breach and result", payload)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	w := testWebhook(t, receiver, Config{})
+
+	if err := w.Notify(context.Background(), breachEvent()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	request := receiver.received()[0]
+	if request.header.Get(signatureHeader) != "" || request.header.Get(timestampHeader) != "" {
+		t.Errorf("got signature headers without a secret: %v", request.header)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	template := `{"text":"{{ printf "%s" .Event | upper }} on {{ .Host }}: {{ range .Breaches }}{{ .Description }}{{ end }}","server":{{ json (index .Results 0).Server }}}`
+	w := testWebhook(t, receiver, Config{WebhookTemplate: template, ContentType: "application/vnd.example+json"})
+
+	if err := w.Notify(context.Background(), breachEvent()); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	request := receiver.received()[0]
+	want := `{"text":"BREACH on test-host: download 42.00 Mbps below 50.00 Mbps (fail)","server":{"id":"1234","name":"Example","country":"Germany"}}`
+	if got := string(request.body); got != want {
+		t.Errorf("got body\n%s\nwant\n%s", got, want)
+	}
+	if got := request.header.Get("Content-Type"); got != "application/vnd.example+json" {
+		t.Errorf("got content type %q, want the configured one", got)
+	}
+}
+
+func TestWebhookInvalidTemplate(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	if _, err := newWebhook(Config{WebhookTemplate: "{{ .Event"}, receiver.server.URL); err == nil {
+		t.Fatal("got no error for an invalid template")
+	}
+}
+
+func TestDeliverCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts int
+	start := time.Now()
+	err := deliver(ctx, 3, func() error {
+		attempts++
+		// The run ends while the delivery waits for its first retry
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return errors.New("delivery failed with status 503 Service Unavailable")
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want it to report the cancellation", err)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, want 1", attempts)
+	}
+	if elapsed := time.Since(start); elapsed >= retryBaseDelay {
+		t.Errorf("delivery took %v, want it to stop waiting when cancelled", elapsed)
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	tests := []struct {
+		status        int
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusNoContent},
+		{status: http.StatusFound},
+		{status: http.StatusBadRequest, wantErr: true, wantPermanent: true},
+		{status: http.StatusForbidden, wantErr: true, wantPermanent: true},
+		{status: http.StatusNotFound, wantErr: true, wantPermanent: true},
+		{status: http.StatusTooManyRequests, wantErr: true},
+		{status: http.StatusInternalServerError, wantErr: true},
+		{status: http.StatusServiceUnavailable, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			err := checkStatus(&http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got := errors.As(err, &permanentError{}); got != tt.wantPermanent {
+				t.Errorf("got permanent %v, want %v", got, tt.wantPermanent)
+			}
+		})
+	}
+}
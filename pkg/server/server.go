@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultDownloadSize is the payload size served when the client requests none
+	DefaultDownloadSize = 25 * 1000 * 1000
+
+	// MaxDownloadSize caps the payload size a client can request
+	MaxDownloadSize = 1000 * 1000 * 1000
+
+	// payloadBlockSize is the size of the random block the payload is built from
+	payloadBlockSize = 1 << 20
+)
+
+// Config holds the test server configuration
+type Config struct {
+	ListenAddress string
+	TLSCertFile   string
+	TLSKeyFile    string
+}
+
+// Server serves download payloads, accepts uploads and echoes latency probes
+// so speedster can measure against a private, controlled target
+type Server struct {
+	config  Config
+	payload []byte
+}
+
+// LoadConfig loads configuration from environment variables
+func LoadConfig() Config {
+	return Config{
+		ListenAddress: getEnv("SERVER_LISTEN_ADDRESS", ":8080"),
+		TLSCertFile:   getEnv("SERVER_TLS_CERT_FILE", ""),
+		TLSKeyFile:    getEnv("SERVER_TLS_KEY_FILE", ""),
+	}
+}
+
+// New creates a new test server
+func New(config Config) (*Server, error) {
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, errors.New("TLS requires both a certificate and a key file")
+	}
+
+	// Random payload so compression along the path cannot inflate the results
+	payload := make([]byte, payloadBlockSize)
+	if _, err := rand.Read(payload); err != nil {
+		return nil, fmt.Errorf("failed to generate payload: %w", err)
+	}
+
+	return &Server{config: config, payload: payload}, nil
+}
+
+// Handler returns the HTTP handler serving the test endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /download", s.handleDownload)
+	mux.HandleFunc("POST /upload", s.handleUpload)
+	mux.HandleFunc("PUT /upload", s.handleUpload)
+	mux.HandleFunc("GET /ping", s.handlePing)
+	return mux
+}
+
+// ListenAndServe serves the test endpoints until the context is cancelled
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	httpServer := &http.Server{
+		Addr:              s.config.ListenAddress,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		if s.config.TLSCertFile != "" {
+			errChan <- httpServer.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
+		} else {
+			errChan <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return httpServer.Shutdown(shutdownCtx)
+	}
+}
+
+// handleDownload streams the requested number of payload bytes
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	size := int64(DefaultDownloadSize)
+	if value := r.URL.Query().Get("size"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 || parsed > MaxDownloadSize {
+			http.Error(w, fmt.Sprintf("size must be between 0 and %d", MaxDownloadSize), http.StatusBadRequest)
+			return
+		}
+		size = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+
+	for remaining := size; remaining > 0; {
+		n := min(remaining, int64(len(s.payload)))
+		if _, err := w.Write(s.payload[:n]); err != nil {
+			return
+		}
+		remaining -= n
+	}
+}
+
+// handleUpload discards the request body and reports how many bytes were received.
+// Clients cut uploads off once their test duration is over, so aborted bodies are expected.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	received, err := io.Copy(io.Discard, r.Body)
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]int64{"bytes": received})
+}
+
+// handlePing answers latency probes as quickly as possible
+func (s *Server) handlePing(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
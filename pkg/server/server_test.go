@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestServer serves the test endpoints on a local port, stopped with the test
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	s, err := New(Config{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	testServer := httptest.NewServer(s.Handler())
+	t.Cleanup(testServer.Close)
+
+	return testServer
+}
+
+func TestNewRequiresCertificateAndKey(t *testing.T) {
+	if _, err := New(Config{TLSCertFile: "cert.pem"}); err == nil {
+		t.Error("got no error for a certificate without a key")
+	}
+	if _, err := New(Config{TLSKeyFile: "key.pem"}); err == nil {
+		t.Error("got no error for a key without a certificate")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	testServer := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantSize   int64
+	}{
+		{name: "default size", wantStatus: http.StatusOK, wantSize: DefaultDownloadSize},
+		{name: "requested size", query: "?size=3000000", wantStatus: http.StatusOK, wantSize: 3000000},
+		{name: "empty", query: "?size=0", wantStatus: http.StatusOK, wantSize: 0},
+		{name: "negative size", query: "?size=-1", wantStatus: http.StatusBadRequest},
+		{name: "size above the maximum", query: "?size=" + strconv.Itoa(MaxDownloadSize+1), wantStatus: http.StatusBadRequest},
+		{name: "invalid size", query: "?size=large", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(testServer.URL + "/download" + tt.query)
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %s, want %d", resp.Status, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			received, err := io.Copy(io.Discard, resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read payload: %v", err)
+			}
+			if received != tt.wantSize || resp.ContentLength != tt.wantSize {
+				t.Errorf("got %d bytes with content length %d, want %d", received, resp.ContentLength, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	testServer := newTestServer(t)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		t.Run(method, func(t *testing.T) {
+			req, err := http.NewRequest(method, testServer.URL+"/upload", strings.NewReader(strings.Repeat("x", 12345)))
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("upload failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var body struct {
+				Bytes int64 `json:"bytes"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK || body.Bytes != 12345 {
+				t.Errorf("got status %s and %d bytes, want 200 and 12345 bytes", resp.Status, body.Bytes)
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	testServer := newTestServer(t)
+
+	resp, err := http.Get(testServer.URL + "/ping")
+	if err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("got status %s, want 204", resp.Status)
+	}
+}
@@ -0,0 +1,166 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BackendType identifies the kind of test server a backend measures against
+type BackendType string
+
+const (
+	// BackendOokla measures against public speedtest.net servers
+	BackendOokla BackendType = "ookla"
+
+	// BackendIperf3 measures against self-hosted iperf3 servers
+	BackendIperf3 BackendType = "iperf3"
+
+	// BackendSpeedster measures against speedster test servers (`speedster server`)
+	BackendSpeedster BackendType = "speedster"
+
+	// BackendHTTP measures plain HTTP downloads and uploads of configured URLs
+	BackendHTTP BackendType = "http"
+
+	// BackendLibreSpeed measures against public or private LibreSpeed instances
+	BackendLibreSpeed BackendType = "librespeed"
+
+	// BackendCloudflare measures against a Cloudflare speed test compatible endpoint
+	BackendCloudflare BackendType = "cloudflare"
+)
+
+// Valid checks if the backend type is valid
+func (b BackendType) Valid() bool {
+	switch b {
+	case BackendOokla, BackendIperf3, BackendSpeedster, BackendHTTP, BackendLibreSpeed, BackendCloudflare:
+		return true
+	default:
+		return false
+	}
+}
+
+// Backend runs measurements against one kind of test server
+type Backend interface {
+	// Name identifies the backend in spans, results and metrics
+	Name() string
+
+	// Servers returns the candidate servers, ordered by preference
+	Servers(ctx context.Context) ([]*Server, error)
+
+	// Ping measures the round trip time to the server count times
+	Ping(ctx context.Context, server *Server, count int) ([]time.Duration, error)
+
+	// Download measures the download throughput from the server
+	Download(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error)
+
+	// Upload measures the upload throughput to the server
+	Upload(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error)
+}
+
+// TransferOptions tunes a single download or upload, zero values keep the backend default
+type TransferOptions struct {
+	// Duration is how long the transfer runs
+	Duration time.Duration
+
+	// Streams is the number of parallel connections
+	Streams int
+}
+
+// withDefaults fills unset options with the given backend defaults
+func (o TransferOptions) withDefaults(duration time.Duration, streams int) TransferOptions {
+	if o.Duration <= 0 {
+		o.Duration = duration
+	}
+	if o.Streams <= 0 {
+		o.Streams = streams
+	}
+
+	return o
+}
+
+// Transfer is the outcome of a download or upload test
+type Transfer struct {
+	// Mbps is the measured throughput
+	Mbps float64
+
+	// Bytes is the amount of data transferred, zero if the backend does not report it
+	Bytes int64
+
+	// TTFB is the time until the first response byte arrived, zero if not measured
+	TTFB time.Duration
+
+	// Duration is the total time the transfer took
+	Duration time.Duration
+
+	// LoadedLatency is the median latency measured during the transfer, zero if not measured
+	LoadedLatency time.Duration
+}
+
+// remoteServerList is implemented by backends that fetch their server list
+// over the network, which makes the list worth caching between runs
+type remoteServerList interface {
+	remoteServerList()
+}
+
+// clientIdentifier is implemented by backends whose servers can tell the
+// public IP address, ISP and ASN of the client
+type clientIdentifier interface {
+	clientInfo(ctx context.Context, server *Server) (ClientInfo, error)
+}
+
+// serialTransfers is implemented by backends whose transfers share client
+// state, so they must not run at the same time even on different servers
+type serialTransfers interface {
+	serialTransfers()
+}
+
+// packetLossMeter is implemented by backends whose servers can measure packet
+// loss. It returns errPacketLossUnsupported if the server cannot.
+type packetLossMeter interface {
+	packetLoss(ctx context.Context, server *Server, duration time.Duration) (float64, error)
+}
+
+// Server is a test server offered by a backend
+type Server struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Country  string        `json:"country,omitempty"`
+	Host     string        `json:"host"`
+	URL      string        `json:"url,omitempty"`
+	Distance float64       `json:"distance,omitempty"`
+	Latency  time.Duration `json:"latency,omitempty"`
+	Jitter   time.Duration `json:"jitter,omitempty"`
+
+	// Endpoints holds backend specific endpoint URLs of the server
+	Endpoints map[string]string `json:"endpoints,omitempty"`
+}
+
+// Info returns the descriptive server information reported with results
+func (s *Server) Info() ServerInfo {
+	return ServerInfo{
+		ID:       s.ID,
+		Name:     s.Name,
+		Country:  s.Country,
+		Distance: s.Distance,
+	}
+}
+
+// newBackend creates a backend of the given type from the configuration
+func newBackend(config Config, backendType BackendType, nw network) (Backend, error) {
+	switch backendType {
+	case BackendOokla:
+		return newOoklaBackend(nw), nil
+	case BackendIperf3:
+		return newIperf3Backend(config, nw), nil
+	case BackendSpeedster:
+		return newSpeedsterBackend(config, nw), nil
+	case BackendHTTP:
+		return newHTTPBackend(config, nw), nil
+	case BackendLibreSpeed:
+		return newLibreSpeedBackend(config, nw), nil
+	case BackendCloudflare:
+		return newCloudflareBackend(config, nw), nil
+	default:
+		return nil, fmt.Errorf("unknown backend '%s'", backendType)
+	}
+}
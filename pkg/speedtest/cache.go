@@ -0,0 +1,137 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serverListCache is a server list snapshot kept between runs
+type serverListCache struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Servers   []*Server `json:"servers"`
+}
+
+// fresh reports whether the cached list is younger than ttl
+func (c *serverListCache) fresh(ttl time.Duration, now time.Time) bool {
+	return c != nil && len(c.Servers) > 0 && now.Sub(c.FetchedAt) < ttl
+}
+
+// servers returns a copy of the cached servers, so measurements never modify the cache itself
+func (c *serverListCache) servers() []*Server {
+	servers := make([]*Server, 0, len(c.Servers))
+	for _, cached := range c.Servers {
+		server := *cached
+		servers = append(servers, &server)
+	}
+
+	return servers
+}
+
+// serverCacheKey identifies the server list of the backend on the current
+// network, as interfaces and proxies may see different lists. The default
+// network keeps the plain backend name.
+func (r *Runner) serverCacheKey() string {
+	key := r.backend.Name()
+	if nw := r.network.key(); nw != (network{family: IPFamilyAuto}).key() {
+		key += "-" + nw
+	}
+
+	return key
+}
+
+// serverCacheStateFile returns the name of the server list cache file of the
+// backend on the current network
+func (r *Runner) serverCacheStateFile() string {
+	// Interface names and proxy hosts may contain characters unfit for file names
+	name := strings.Map(func(c rune) rune {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-' || c == '.' {
+			return c
+		}
+		return '_'
+	}, r.serverCacheKey())
+
+	return fmt.Sprintf("servers-%s.json", name)
+}
+
+// loadServerCache returns the cached server list of the current backend and network,
+// preferring the in-memory copy over the one in the state directory
+func (r *Runner) loadServerCache() *serverListCache {
+	if cache, ok := r.serverCaches[r.serverCacheKey()]; ok || r.config.StateDir == "" {
+		return cache
+	}
+
+	var cache serverListCache
+	if err := ReadState(r.config.StateDir, r.serverCacheStateFile(), &cache); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load server list cache: %v\n", err)
+		return nil
+	}
+	if len(cache.Servers) == 0 {
+		return nil
+	}
+
+	r.serverCaches[r.serverCacheKey()] = &cache
+	return &cache
+}
+
+// storeServerCache keeps the server list in memory and in the state directory
+func (r *Runner) storeServerCache(servers []*Server, now time.Time) {
+	cache := &serverListCache{FetchedAt: now, Servers: servers}
+	cache.Servers = cache.servers()
+	r.serverCaches[r.serverCacheKey()] = cache
+
+	if r.config.StateDir == "" {
+		return
+	}
+	if err := WriteState(r.config.StateDir, r.serverCacheStateFile(), cache); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save server list cache: %v\n", err)
+	}
+}
+
+// fetchServers returns the backend's server list. Lists fetched over the
+// network are served from the cache while it is fresh, and a stale cached
+// list is used as fallback when the server list API is unreachable, so tests
+// can still run against known servers.
+func (r *Runner) fetchServers(ctx context.Context) ([]*Server, error) {
+	if _, ok := r.backend.(remoteServerList); !ok {
+		return r.backend.Servers(ctx)
+	}
+
+	span := trace.SpanFromContext(ctx)
+	now := time.Now()
+	cache := r.loadServerCache()
+
+	if cache.fresh(r.config.ServerCacheTTL, now) {
+		span.SetAttributes(
+			attribute.String("server_list.source", "cache"),
+			attribute.Float64("server_list.age_seconds", now.Sub(cache.FetchedAt).Seconds()),
+		)
+		return cache.servers(), nil
+	}
+
+	servers, err := r.backend.Servers(ctx)
+	if err != nil {
+		if cache == nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(os.Stderr, "Warning: Failed to fetch server list, using cached list from %s: %v\n", cache.FetchedAt.Format(time.RFC3339), err)
+		span.RecordError(err)
+		span.SetAttributes(
+			attribute.String("server_list.source", "stale_cache"),
+			attribute.Float64("server_list.age_seconds", now.Sub(cache.FetchedAt).Seconds()),
+		)
+		return cache.servers(), nil
+	}
+
+	r.storeServerCache(servers, now)
+	span.SetAttributes(attribute.String("server_list.source", "api"))
+
+	return servers, nil
+}
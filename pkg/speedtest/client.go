@@ -0,0 +1,206 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// clientStateFile keeps the last detected ISP of every run between runs
+const clientStateFile = "client.json"
+
+// ClientInfo describes the connection measured, as seen by the servers
+type ClientInfo struct {
+	IP  string `json:"ip,omitempty"`
+	ISP string `json:"isp,omitempty"`
+	ASN int    `json:"asn,omitempty"`
+}
+
+// Known reports whether anything about the client was detected
+func (c ClientInfo) Known() bool {
+	return c.IP != "" || c.ISP != "" || c.ASN != 0
+}
+
+// String formats the ISP and ASN, e.g. "Deutsche Telekom AG (AS3320)"
+func (c ClientInfo) String() string {
+	switch {
+	case c.ISP != "" && c.ASN != 0:
+		return fmt.Sprintf("%s (AS%d)", c.ISP, c.ASN)
+	case c.ASN != 0:
+		return fmt.Sprintf("AS%d", c.ASN)
+	default:
+		return c.ISP
+	}
+}
+
+// sameNetwork reports whether both describe the same ISP, comparing the ASN
+// when both know it since ISP names vary between lookups
+func (c ClientInfo) sameNetwork(other ClientInfo) bool {
+	if c.ASN != 0 && other.ASN != 0 {
+		return c.ASN == other.ASN
+	}
+
+	return strings.EqualFold(c.ISP, other.ISP)
+}
+
+// parseASOrganization splits "AS3320 Deutsche Telekom AG" into ASN and organization
+func parseASOrganization(value string) (int, string) {
+	value = strings.TrimSpace(value)
+	prefix, rest, _ := strings.Cut(value, " ")
+	if asn, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(prefix), "AS")); err == nil && len(prefix) > 2 {
+		return asn, strings.TrimSpace(rest)
+	}
+
+	return 0, value
+}
+
+// parseASN reads an ASN given as number or as "AS3320" string
+func parseASN(value any) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case string:
+		asn, _ := parseASOrganization(v)
+		return asn
+	default:
+		return 0
+	}
+}
+
+// lookupClientInfo asks a JSON IP lookup service for the public IP address,
+// ISP and ASN of the client. The field names of the common services
+// (ipinfo.io, ip-api.com, ifconfig.co, ipapi.co) are understood.
+func lookupClientInfo(ctx context.Context, client *http.Client, lookupURL string) (ClientInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lookupURL, nil)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ClientInfo{}, fmt.Errorf("client info lookup failed with status %s", resp.Status)
+	}
+
+	var fields map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&fields); err != nil {
+		return ClientInfo{}, fmt.Errorf("failed to parse client info: %w", err)
+	}
+
+	str := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := fields[key].(string); ok && value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	info := ClientInfo{
+		IP:  str("ip", "query", "clientIp", "client_ip"),
+		ISP: str("isp", "asn_org", "asOrganization", "org"),
+	}
+	for _, key := range []string{"asn", "as"} {
+		if asn := parseASN(fields[key]); asn != 0 {
+			info.ASN = asn
+			break
+		}
+	}
+	// ipinfo.io only reports "org" as "AS3320 Deutsche Telekom AG"
+	if asn, org := parseASOrganization(info.ISP); asn != 0 {
+		info.ASN, info.ISP = asn, org
+	}
+
+	if !info.Known() {
+		return ClientInfo{}, fmt.Errorf("client info lookup returned no IP address, ISP or ASN")
+	}
+
+	return info, nil
+}
+
+// clientRecord is the last ISP detected for a run, kept between runs
+type clientRecord struct {
+	ClientInfo
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// detectClient looks up who the servers see as the client, from the lookup
+// endpoint if configured or the backend if it can tell, and flags ISP changes
+// since the previous run. The IP address is dropped unless it may be recorded.
+func (r *Runner) detectClient(ctx context.Context, key string, server *Server) (ClientInfo, bool) {
+	span := trace.SpanFromContext(ctx)
+
+	var info ClientInfo
+	var err error
+	identifier, ok := r.backend.(clientIdentifier)
+	switch {
+	case r.config.ClientInfoURL != "":
+		info, err = lookupClientInfo(ctx, r.network.httpClient(), r.config.ClientInfoURL)
+	case ok:
+		info, err = identifier.clientInfo(ctx, server)
+	default:
+		return ClientInfo{}, false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to look up client information: %v\n", err)
+		span.RecordError(err)
+		return ClientInfo{}, false
+	}
+
+	if !r.config.RecordClientIP {
+		info.IP = ""
+	}
+	span.SetAttributes(
+		attribute.String("client.isp", info.ISP),
+		attribute.Int("client.asn", info.ASN),
+	)
+	if info.IP != "" {
+		span.SetAttributes(attribute.String("client.ip", info.IP))
+	}
+
+	changed := r.recordClient(key, info)
+	span.SetAttributes(attribute.Bool("client.isp_changed", changed))
+
+	return info, changed
+}
+
+// recordClient stores the ISP of the run and reports whether it differs from
+// the one stored by the previous run
+func (r *Runner) recordClient(key string, info ClientInfo) bool {
+	if r.config.StateDir == "" || (info.ISP == "" && info.ASN == 0) {
+		return false
+	}
+
+	records := make(map[string]clientRecord)
+	if err := ReadState(r.config.StateDir, clientStateFile, &records); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load client state: %v\n", err)
+	}
+
+	previous, seen := records[key]
+	changed := seen && !previous.sameNetwork(info)
+	if changed {
+		fmt.Fprintf(os.Stderr, "Warning: ISP of %s changed from %s to %s\n", key, previous.ClientInfo, info)
+	}
+
+	// Only the ISP is kept, the IP address changes too often to compare
+	records[key] = clientRecord{ClientInfo: ClientInfo{ISP: info.ISP, ASN: info.ASN}, DetectedAt: time.Now()}
+	if err := WriteState(r.config.StateDir, clientStateFile, records); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to save client state: %v\n", err)
+	}
+
+	return changed
+}
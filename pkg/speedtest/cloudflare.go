@@ -0,0 +1,379 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// CloudflareDefaultBaseURL is the public Cloudflare speed test
+	CloudflareDefaultBaseURL = "https://speed.cloudflare.com"
+
+	// cloudflareMinRequestDuration excludes requests too short to tell the bandwidth
+	cloudflareMinRequestDuration = 10 * time.Millisecond
+
+	// cloudflareFinishRequestDuration stops moving on to larger sizes once a
+	// request takes this long, the connection is saturated by then
+	cloudflareFinishRequestDuration = time.Second
+)
+
+// cloudflareStep is a number of requests of the same size
+type cloudflareStep struct {
+	bytes int64
+	count int
+}
+
+// Progressively larger requests, modeled on the Cloudflare speed test
+var (
+	cloudflareDownloadSteps = []cloudflareStep{
+		{bytes: 100_000, count: 10},
+		{bytes: 1_000_000, count: 8},
+		{bytes: 10_000_000, count: 6},
+		{bytes: 25_000_000, count: 4},
+		{bytes: 100_000_000, count: 3},
+	}
+	cloudflareUploadSteps = []cloudflareStep{
+		{bytes: 100_000, count: 8},
+		{bytes: 1_000_000, count: 6},
+		{bytes: 10_000_000, count: 4},
+		{bytes: 25_000_000, count: 4},
+		{bytes: 50_000_000, count: 3},
+	}
+)
+
+// cloudflareSample is the timing of a single request
+type cloudflareSample struct {
+	bytes      int64
+	ttfb       time.Duration
+	duration   time.Duration
+	serverTime time.Duration
+}
+
+// mbps returns the throughput of the request, excluding the server processing time
+func (s cloudflareSample) mbps() float64 {
+	seconds := (s.duration - s.serverTime).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+
+	return float64(s.bytes) * 8 / seconds / 1e6
+}
+
+// cloudflareMeta is the response of the meta endpoint describing the connection
+type cloudflareMeta struct {
+	ClientIP       string `json:"clientIp"`
+	ASN            int    `json:"asn"`
+	ASOrganization string `json:"asOrganization"`
+	Colo           string `json:"colo"`
+	City           string `json:"city"`
+	Country        string `json:"country"`
+}
+
+// cloudflareBackend measures with progressively sized HTTP requests against a
+// Cloudflare speed test compatible endpoint (__down, __up)
+type cloudflareBackend struct {
+	baseURL    string
+	client     *http.Client
+	percentile float64
+}
+
+func newCloudflareBackend(config Config, nw network) *cloudflareBackend {
+	return &cloudflareBackend{
+		baseURL:    strings.TrimSuffix(config.CloudflareBaseURL, "/"),
+		client:     nw.httpClient(),
+		percentile: config.CloudflarePercentile,
+	}
+}
+
+func (b *cloudflareBackend) Name() string {
+	return string(BackendCloudflare)
+}
+
+// Servers returns the configured base URL as the only server, named after the
+// data center serving it if the endpoint tells
+func (b *cloudflareBackend) Servers(ctx context.Context) ([]*Server, error) {
+	u, err := url.Parse(b.baseURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid Cloudflare base URL '%s'", b.baseURL)
+	}
+
+	server := &Server{
+		ID:   b.baseURL,
+		Name: u.Hostname(),
+		Host: u.Host,
+		URL:  b.baseURL,
+	}
+
+	// Local stand-ins usually have no meta endpoint, the host name is good enough then
+	if meta, err := b.meta(ctx); err == nil && meta.Colo != "" {
+		server.Name = fmt.Sprintf("Cloudflare %s (%s)", meta.Colo, meta.City)
+		server.Country = meta.Country
+	}
+
+	return []*Server{server}, nil
+}
+
+// Ping measures the latency of empty downloads, excluding the server processing time.
+// A first request sets up the connection and is not counted.
+func (b *cloudflareBackend) Ping(ctx context.Context, _ *Server, count int) ([]time.Duration, error) {
+	if _, err := b.ping(ctx); err != nil {
+		return nil, err
+	}
+
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		latency, err := b.ping(ctx)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, latency)
+	}
+
+	return samples, nil
+}
+
+func (b *cloudflareBackend) ping(ctx context.Context) (time.Duration, error) {
+	sample, err := b.download(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(sample.ttfb-sample.serverTime, 0), nil
+}
+
+// Download runs the download progression, requests are sequential so the streams option is ignored
+func (b *cloudflareBackend) Download(ctx context.Context, _ *Server, opts TransferOptions) (Transfer, error) {
+	return b.measure(ctx, cloudflareDownloadSteps, opts.Duration, b.download)
+}
+
+// Upload runs the upload progression, requests are sequential so the streams option is ignored
+func (b *cloudflareBackend) Upload(ctx context.Context, _ *Server, opts TransferOptions) (Transfer, error) {
+	return b.measure(ctx, cloudflareUploadSteps, opts.Duration, b.upload)
+}
+
+// measure runs the steps one request at a time while probing the latency in
+// the background. Steps stop growing once a request takes long enough to
+// saturate the connection, or once the duration is used up if one is given.
+// The throughput is the configured percentile of the per-request throughput.
+func (b *cloudflareBackend) measure(ctx context.Context, steps []cloudflareStep, duration time.Duration, request func(context.Context, int64) (cloudflareSample, error)) (Transfer, error) {
+	stopProbing := b.probeLatency(ctx)
+
+	start := time.Now()
+	var samples []cloudflareSample
+	for _, step := range steps {
+		if duration > 0 && time.Since(start) >= duration {
+			break
+		}
+		saturated := false
+		for i := 0; i < step.count; i++ {
+			sample, err := request(ctx, step.bytes)
+			if err != nil {
+				stopProbing()
+				return Transfer{}, err
+			}
+			samples = append(samples, sample)
+			saturated = saturated || sample.duration >= cloudflareFinishRequestDuration
+		}
+		if saturated {
+			break
+		}
+	}
+	elapsed := time.Since(start)
+	loadedLatency := stopProbing()
+
+	// Requests that were over too quickly say more about latency than bandwidth
+	var speeds []float64
+	var ttfbs []time.Duration
+	var bytes int64
+	for _, sample := range samples {
+		bytes += sample.bytes
+		if sample.ttfb > 0 {
+			ttfbs = append(ttfbs, sample.ttfb)
+		}
+		if sample.duration >= cloudflareMinRequestDuration {
+			speeds = append(speeds, sample.mbps())
+		}
+	}
+	if len(speeds) == 0 {
+		for _, sample := range samples {
+			speeds = append(speeds, sample.mbps())
+		}
+	}
+
+	return Transfer{
+		Mbps:          percentile(speeds, b.percentile),
+		Bytes:         bytes,
+		TTFB:          median(ttfbs),
+		Duration:      elapsed,
+		LoadedLatency: loadedLatency,
+	}, nil
+}
+
+// probeLatency pings in the background until the returned function is called,
+// which returns the median latency measured under load
+func (b *cloudflareBackend) probeLatency(ctx context.Context) func() time.Duration {
+	probeCtx, cancel := context.WithCancel(ctx)
+	var samples []time.Duration
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-probeCtx.Done():
+				return
+			case <-time.After(loadedLatencyInterval):
+			}
+			if latency, err := b.ping(probeCtx); err == nil {
+				samples = append(samples, latency)
+			}
+		}
+	}()
+
+	return func() time.Duration {
+		cancel()
+		wg.Wait()
+		return median(samples)
+	}
+}
+
+func (b *cloudflareBackend) download(ctx context.Context, size int64) (cloudflareSample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.baseURL+"/__down?bytes="+strconv.FormatInt(size, 10), nil)
+	if err != nil {
+		return cloudflareSample{}, err
+	}
+
+	start := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return cloudflareSample{}, err
+	}
+	defer resp.Body.Close()
+	ttfb := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		return cloudflareSample{}, fmt.Errorf("download failed with status %s", resp.Status)
+	}
+
+	var counter atomic.Int64
+	if err := drainBody(resp.Body, &counter); err != nil {
+		return cloudflareSample{}, err
+	}
+
+	return cloudflareSample{
+		bytes:      counter.Load(),
+		ttfb:       ttfb,
+		duration:   time.Since(start),
+		serverTime: serverTiming(resp.Header),
+	}, nil
+}
+
+func (b *cloudflareBackend) upload(ctx context.Context, size int64) (cloudflareSample, error) {
+	var counter atomic.Int64
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/__up", newPayloadReader(size, &counter))
+	if err != nil {
+		return cloudflareSample{}, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	start := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return cloudflareSample{}, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return cloudflareSample{}, fmt.Errorf("upload failed with status %s", resp.Status)
+	}
+
+	return cloudflareSample{
+		bytes:      counter.Load(),
+		duration:   time.Since(start),
+		serverTime: serverTiming(resp.Header),
+	}, nil
+}
+
+// meta fetches the description of the connection from the meta endpoint
+func (b *cloudflareBackend) meta(ctx context.Context) (*cloudflareMeta, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.baseURL+"/meta", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("meta request failed with status %s", resp.Status)
+	}
+
+	var meta cloudflareMeta
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("failed to parse meta response: %w", err)
+	}
+
+	return &meta, nil
+}
+
+// clientInfo reports the public IP address and network of the client from the meta endpoint
+func (b *cloudflareBackend) clientInfo(ctx context.Context, _ *Server) (ClientInfo, error) {
+	meta, err := b.meta(ctx)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+
+	return ClientInfo{IP: meta.ClientIP, ISP: meta.ASOrganization, ASN: meta.ASN}, nil
+}
+
+// serverTiming returns the request processing time the server reports in the
+// Server-Timing header, e.g. "cfRequestDuration;dur=12.3"
+func serverTiming(header http.Header) time.Duration {
+	for _, metric := range strings.Split(header.Get("Server-Timing"), ",") {
+		for _, param := range strings.Split(metric, ";") {
+			value, ok := strings.CutPrefix(strings.TrimSpace(param), "dur=")
+			if !ok {
+				continue
+			}
+			if ms, err := strconv.ParseFloat(value, 64); err == nil {
+				return time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+	}
+
+	return 0
+}
+
+// percentile returns the p-th percentile (0-1) of the values, interpolating between neighbours
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	rank := p * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(rank-float64(lower))
+}
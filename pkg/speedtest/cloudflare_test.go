@@ -0,0 +1,242 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	// cloudflareStandInPingDelay is how long the stand-in takes to answer a latency probe
+	cloudflareStandInPingDelay = 25 * time.Millisecond
+
+	// cloudflareStandInServerTime is the processing time the stand-in reports
+	// for latency probes, which the client subtracts
+	cloudflareStandInServerTime = 5 * time.Millisecond
+)
+
+// cloudflareStandInRates are the throughputs in Mbps the stand-in paces
+// successive transfers to, so the percentiles of a run are known
+var cloudflareStandInRates = []float64{4, 8, 12, 16}
+
+// newCloudflareStandIn serves __down, __up and meta like the Cloudflare speed
+// test on a local port, stopped with the test
+func newCloudflareStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var downloads, uploads atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /__down", func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		size, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+
+		// Empty downloads are latency probes
+		if size == 0 {
+			time.Sleep(cloudflareStandInPingDelay)
+			w.Header().Set("Server-Timing", "cfRequestDuration;dur="+strconv.FormatFloat(cloudflareStandInServerTime.Seconds()*1000, 'f', 1, 64))
+			return
+		}
+
+		paceTransfer(start, size, downloads.Add(1)-1)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		_, _ = w.Write(make([]byte, size))
+	})
+	mux.HandleFunc("POST /__up", func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		size, _ := io.Copy(io.Discard, r.Body)
+		paceTransfer(start, size, uploads.Add(1)-1)
+	})
+	mux.HandleFunc("GET /meta", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(cloudflareMeta{
+			ClientIP:       "192.0.2.1",
+			ASN:            64496,
+			ASOrganization: "Example ISP",
+			Colo:           "FRA",
+			City:           "Frankfurt",
+			Country:        "DE",
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// paceTransfer waits until the transfer of size bytes that started at start
+// took as long as it would at the rate of the request
+func paceTransfer(start time.Time, size, request int64) {
+	rate := cloudflareStandInRates[request%int64(len(cloudflareStandInRates))]
+	time.Sleep(time.Until(start.Add(time.Duration(float64(size) * 8 / rate / 1e6 * float64(time.Second)))))
+}
+
+// testCloudflareBackend returns a backend measuring against the stand-in
+func testCloudflareBackend(server *httptest.Server, percentile float64) *cloudflareBackend {
+	return newCloudflareBackend(Config{CloudflareBaseURL: server.URL + "/", CloudflarePercentile: percentile}, network{family: IPFamilyAuto})
+}
+
+// checkMbps fails unless the measured throughput is close to the paced one.
+// Request overhead only ever slows the transfer down.
+func checkMbps(t *testing.T, got, want float64) {
+	t.Helper()
+
+	if got < want*0.8 || got > want*1.02 {
+		t.Errorf("got %.2f Mbps, want about %.2f Mbps", got, want)
+	}
+}
+
+// checkProbeLatency fails unless the latency is the stand-in's probe delay
+// without the reported server time
+func checkProbeLatency(t *testing.T, got time.Duration) {
+	t.Helper()
+
+	want := cloudflareStandInPingDelay - cloudflareStandInServerTime
+	if got < want || got > want+15*time.Millisecond {
+		t.Errorf("got latency %v, want about %v", got, want)
+	}
+}
+
+func TestCloudflareServers(t *testing.T) {
+	standIn := newCloudflareStandIn(t)
+	b := testCloudflareBackend(standIn, 0.9)
+
+	servers, err := b.Servers(context.Background())
+	if err != nil {
+		t.Fatalf("Servers failed: %v", err)
+	}
+	if len(servers) != 1 || servers[0].ID != standIn.URL {
+		t.Fatalf("got servers %+v, want the base URL without trailing slash", servers)
+	}
+	if servers[0].Name != "Cloudflare FRA (Frankfurt)" || servers[0].Country != "DE" {
+		t.Errorf("got server %+v, want it named after the data center", servers[0])
+	}
+
+	client, err := b.clientInfo(context.Background(), servers[0])
+	if err != nil {
+		t.Fatalf("clientInfo failed: %v", err)
+	}
+	if client != (ClientInfo{IP: "192.0.2.1", ISP: "Example ISP", ASN: 64496}) {
+		t.Errorf("got client %+v, want the meta endpoint's", client)
+	}
+}
+
+func TestCloudflarePing(t *testing.T) {
+	standIn := newCloudflareStandIn(t)
+
+	samples, err := testCloudflareBackend(standIn, 0.9).Ping(context.Background(), nil, 3)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	for _, sample := range samples {
+		checkProbeLatency(t, sample)
+	}
+}
+
+func TestCloudflareDownload(t *testing.T) {
+	// A duration that is over during the first step stops after its 10 requests of
+	// 100 kB, paced to 4, 8, 12, 16, 4, 8, 12, 16, 4 and 8 Mbps
+	tests := []struct {
+		percentile float64
+		wantMbps   float64
+	}{
+		{percentile: 0.9, wantMbps: 16},
+		{percentile: 0.5, wantMbps: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatFloat(tt.percentile, 'f', -1, 64), func(t *testing.T) {
+			standIn := newCloudflareStandIn(t)
+
+			transfer, err := testCloudflareBackend(standIn, tt.percentile).Download(context.Background(), nil, TransferOptions{Duration: time.Millisecond})
+			if err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+
+			checkMbps(t, transfer.Mbps, tt.wantMbps)
+			if transfer.Bytes != 10*100_000 {
+				t.Errorf("got %d bytes, want the 10 requests of the first step", transfer.Bytes)
+			}
+			if transfer.TTFB <= 0 {
+				t.Errorf("got TTFB %v, want the median time to the first byte", transfer.TTFB)
+			}
+			checkProbeLatency(t, transfer.LoadedLatency)
+		})
+	}
+}
+
+func TestCloudflareUpload(t *testing.T) {
+	standIn := newCloudflareStandIn(t)
+
+	// The first step has 8 requests of 100 kB, paced to 4, 8, 12, 16, 4, 8, 12 and 16 Mbps
+	transfer, err := testCloudflareBackend(standIn, 0.5).Upload(context.Background(), nil, TransferOptions{Duration: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	checkMbps(t, transfer.Mbps, 10)
+	if transfer.Bytes != 8*100_000 {
+		t.Errorf("got %d bytes, want the 8 requests of the first step", transfer.Bytes)
+	}
+	checkProbeLatency(t, transfer.LoadedLatency)
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{name: "no values", p: 0.9, want: 0},
+		{name: "single value", values: []float64{42}, p: 0.9, want: 42},
+		{name: "median", values: []float64{30, 10, 20}, p: 0.5, want: 20},
+		{name: "interpolated", values: []float64{40, 10, 30, 20}, p: 0.5, want: 25},
+		{name: "maximum", values: []float64{10, 50, 30}, p: 1, want: 50},
+		{name: "minimum", values: []float64{10, 50, 30}, p: 0, want: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.values, tt.p); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerTiming(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "", want: 0},
+		{header: "cfRequestDuration;dur=12.5", want: 12500 * time.Microsecond},
+		{header: "cache;desc=hit, cfRequestDuration;dur=3", want: 3 * time.Millisecond},
+		{header: "cfRequestDuration;dur=fast", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Server-Timing", tt.header)
+			}
+			if got := serverTiming(header); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
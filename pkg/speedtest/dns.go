@@ -0,0 +1,373 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsLookupTimeout bounds a single resolution, slower answers count as failures
+const dnsLookupTimeout = 5 * time.Second
+
+// DNSProtocol is the transport a resolver is queried over
+type DNSProtocol string
+
+const (
+	// DNSProtocolSystem uses the resolver configured on the host
+	DNSProtocolSystem DNSProtocol = "system"
+
+	// DNSProtocolUDP queries a DNS server over UDP
+	DNSProtocolUDP DNSProtocol = "udp"
+
+	// DNSProtocolTCP queries a DNS server over TCP
+	DNSProtocolTCP DNSProtocol = "tcp"
+
+	// DNSProtocolHTTPS queries a DNS-over-HTTPS endpoint (RFC 8484)
+	DNSProtocolHTTPS DNSProtocol = "https"
+)
+
+// DNSResolver is a resolver the DNS phase measures
+type DNSResolver struct {
+	// Name identifies the resolver in spans and metrics, e.g. "udp://1.1.1.1:53"
+	Name     string
+	Protocol DNSProtocol
+	// Address is host:port for UDP and TCP, the query URL for DNS-over-HTTPS
+	Address string
+}
+
+// DNSResult is the resolution of one hostname by one resolver
+type DNSResult struct {
+	Resolver  string
+	Protocol  DNSProtocol
+	Hostname  string
+	Interface string
+	IPFamily  string
+	// Proxy is the proxy a DNS-over-HTTPS lookup went through, empty for direct lookups
+	Proxy     string
+	Duration  time.Duration
+	Addresses int
+	// Error is the failure reason, empty if the hostname resolved
+	Error string
+}
+
+// systemResolver is the host's own resolver, always measured
+var systemResolver = DNSResolver{Name: string(DNSProtocolSystem), Protocol: DNSProtocolSystem}
+
+// parseDNSResolvers parses resolvers given as udp://host[:port], tcp://host[:port],
+// https://host/path (http:// for local endpoints) or a plain host[:port] queried over UDP
+func parseDNSResolvers(values []string) ([]DNSResolver, error) {
+	resolvers := make([]DNSResolver, 0, len(values))
+	for _, value := range values {
+		protocol := DNSProtocolUDP
+		address := value
+		if scheme, rest, ok := strings.Cut(value, "://"); ok {
+			protocol = DNSProtocol(strings.ToLower(scheme))
+			address = rest
+		}
+		// Plain HTTP serves DNS-over-HTTPS endpoints behind a local TLS terminator
+		if protocol == "http" {
+			protocol = DNSProtocolHTTPS
+		}
+
+		switch protocol {
+		case DNSProtocolUDP, DNSProtocolTCP:
+			if address == "" {
+				return nil, fmt.Errorf("invalid DNS resolver '%s', missing address", value)
+			}
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				address = net.JoinHostPort(strings.Trim(address, "[]"), "53")
+			}
+			resolvers = append(resolvers, DNSResolver{Name: fmt.Sprintf("%s://%s", protocol, address), Protocol: protocol, Address: address})
+		case DNSProtocolHTTPS:
+			parsed, err := url.Parse(value)
+			if err != nil || parsed.Host == "" {
+				return nil, fmt.Errorf("invalid DNS-over-HTTPS resolver '%s'", value)
+			}
+			resolvers = append(resolvers, DNSResolver{Name: value, Protocol: protocol, Address: value})
+		default:
+			return nil, fmt.Errorf("invalid DNS resolver '%s', expected udp://, tcp:// or https://", value)
+		}
+	}
+
+	return resolvers, nil
+}
+
+// runDNSTest resolves every configured hostname with the system resolver and
+// every explicit resolver. Failed resolutions are reported, not returned, so
+// slow or broken DNS never fails the speed test itself.
+func (r *Runner) runDNSTest(ctx context.Context) []DNSResult {
+	if len(r.config.DNSHostnames) == 0 {
+		return nil
+	}
+
+	// Resolution differs by interface and IP family, DNS-over-HTTPS also by proxy path
+	resolvers := append([]DNSResolver{systemResolver}, r.config.DNSResolvers...)
+	var results []DNSResult
+	for _, resolver := range resolvers {
+		for _, nw := range r.distinctNetworks(resolver.Protocol == DNSProtocolHTTPS) {
+			results = append(results, r.runDNSResolver(ctx, nw, resolver)...)
+		}
+	}
+
+	return results
+}
+
+// runDNSResolver resolves the hostnames one after another with a single resolver
+func (r *Runner) runDNSResolver(ctx context.Context, nw network, resolver DNSResolver) []DNSResult {
+	ctx, span := tracer.Start(ctx, "speedtest.dns_test")
+	defer span.End()
+
+	var proxy string
+	if resolver.Protocol == DNSProtocolHTTPS {
+		proxy = nw.proxyFor(&Server{URL: resolver.Address})
+	}
+	span.SetAttributes(
+		attribute.String("dns.resolver", resolver.Name),
+		attribute.String("dns.protocol", string(resolver.Protocol)),
+		attribute.Int("dns.hostnames", len(r.config.DNSHostnames)),
+		attribute.String("interface", nw.iface),
+		attribute.String("ip_family", nw.family.label()),
+		attribute.String("proxy", proxy),
+	)
+
+	lookup := resolver.lookup(nw)
+	results := make([]DNSResult, 0, len(r.config.DNSHostnames))
+	var failures int
+	var total time.Duration
+	for _, hostname := range r.config.DNSHostnames {
+		result := DNSResult{
+			Resolver:  resolver.Name,
+			Protocol:  resolver.Protocol,
+			Hostname:  hostname,
+			Interface: nw.iface,
+			IPFamily:  nw.family.label(),
+			Proxy:     proxy,
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+		start := time.Now()
+		addresses, err := lookup(lookupCtx, hostname)
+		result.Duration = time.Since(start)
+		cancel()
+
+		event := []attribute.KeyValue{
+			attribute.String("dns.hostname", hostname),
+			attribute.Int64("dns.duration_nanos", result.Duration.Nanoseconds()),
+		}
+		if err != nil {
+			failures++
+			result.Error = err.Error()
+			event = append(event, attribute.String("dns.error", result.Error))
+		} else {
+			total += result.Duration
+			result.Addresses = addresses
+			event = append(event, attribute.Int("dns.addresses", addresses))
+		}
+		span.AddEvent("dns.lookup", trace.WithAttributes(event...))
+		results = append(results, result)
+	}
+
+	span.SetAttributes(attribute.Int("dns.failures", failures))
+	if resolved := len(results) - failures; resolved > 0 {
+		span.SetAttributes(attribute.Int64("dns.mean_duration_nanos", (total / time.Duration(resolved)).Nanoseconds()))
+	}
+	if failures == len(results) {
+		span.SetStatus(codes.Error, "all lookups failed")
+	} else {
+		span.SetStatus(codes.Ok, "dns test completed")
+	}
+
+	return results
+}
+
+// exchange sends a packed DNS query and returns the packed answer
+type exchange func(ctx context.Context, query []byte) ([]byte, error)
+
+// lookup returns the resolution function of the resolver, reporting the number
+// of IPv4 and IPv6 addresses the hostname resolved to
+func (d DNSResolver) lookup(nw network) func(ctx context.Context, hostname string) (int, error) {
+	var send exchange
+	switch d.Protocol {
+	case DNSProtocolUDP:
+		send = d.exchangeUDP(nw)
+	case DNSProtocolTCP:
+		send = d.exchangeTCP(nw)
+	case DNSProtocolHTTPS:
+		send = d.exchangeHTTPS(nw.httpClient())
+	default:
+		resolver := nw.systemResolver(dnsLookupTimeout)
+		return func(ctx context.Context, hostname string) (int, error) {
+			addrs, err := resolver.LookupHost(ctx, hostname)
+			return len(addrs), err
+		}
+	}
+
+	return func(ctx context.Context, hostname string) (int, error) {
+		return resolve(ctx, send, hostname)
+	}
+}
+
+// resolve queries the A and AAAA records in parallel, like the system resolver does
+func resolve(ctx context.Context, send exchange, hostname string) (int, error) {
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	counts := make([]int, len(types))
+	errs := make([]error, len(types))
+
+	var wg sync.WaitGroup
+	for i, qtype := range types {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts[i], errs[i] = query(ctx, send, hostname, qtype)
+		}()
+	}
+	wg.Wait()
+
+	// An answer for either family resolves the hostname
+	addresses := counts[0] + counts[1]
+	if addresses > 0 {
+		return addresses, nil
+	}
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, fmt.Errorf("no addresses for %s", hostname)
+}
+
+// query sends a single DNS query and counts the answers of the type
+func query(ctx context.Context, send exchange, hostname string, qtype dnsmessage.Type) (int, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(hostname, ".") + ".")
+	if err != nil {
+		return 0, fmt.Errorf("invalid hostname %s: %w", hostname, err)
+	}
+
+	message := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.UintN(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := message.Pack()
+	if err != nil {
+		return 0, fmt.Errorf("failed to build DNS query: %w", err)
+	}
+
+	answer, err := send(ctx, packed)
+	if err != nil {
+		return 0, err
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(answer); err != nil {
+		return 0, fmt.Errorf("failed to parse DNS answer: %w", err)
+	}
+	if reply.ID != message.ID {
+		return 0, fmt.Errorf("DNS answer for %s does not match the query", hostname)
+	}
+	if reply.RCode != dnsmessage.RCodeSuccess {
+		return 0, fmt.Errorf("DNS query for %s failed: %s", hostname, reply.RCode)
+	}
+
+	var count int
+	for _, record := range reply.Answers {
+		if record.Header.Type == qtype {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// exchangeUDP sends every query in a datagram of its own
+func (d DNSResolver) exchangeUDP(nw network) exchange {
+	dialer := nw.udpDialer(dnsLookupTimeout)
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		conn, err := dialer.DialContext(ctx, "udp", d.Address)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		answer := make([]byte, 65535)
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+
+		return answer[:n], nil
+	}
+}
+
+// exchangeTCP sends every query over a connection of its own, framed by its length (RFC 1035 4.2.2)
+func (d DNSResolver) exchangeTCP(nw network) exchange {
+	dialer := nw.dialer(dnsLookupTimeout)
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		conn, err := dialer.DialContext(ctx, "tcp", d.Address)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		answer := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			return nil, err
+		}
+
+		return answer, nil
+	}
+}
+
+// exchangeHTTPS posts every query as application/dns-message (RFC 8484)
+func (d DNSResolver) exchangeHTTPS(client *http.Client) exchange {
+	return func(ctx context.Context, query []byte) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Address, bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("DNS-over-HTTPS query failed with status %s", resp.Status)
+		}
+
+		return io.ReadAll(io.LimitReader(resp.Body, 65535))
+	}
+}
@@ -0,0 +1,226 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const httpDefaultStreams = 4
+
+// httpTarget is a download and/or upload URL measured as one server, both
+// as configured, including credentials
+type httpTarget struct {
+	download string
+	upload   string
+}
+
+// pingURL returns the URL latency is measured against
+func (t httpTarget) pingURL() string {
+	if t.download != "" {
+		return t.download
+	}
+
+	return t.upload
+}
+
+// httpBackend measures plain HTTP transfers of configured URLs, e.g. a CDN
+// object or an artifact store. Every stream transfers the object once.
+type httpBackend struct {
+	targets      map[string]httpTarget
+	order        []string
+	headers      map[string]string
+	uploadMethod string
+	uploadSize   int64
+	client       *http.Client
+	streams      int
+}
+
+func newHTTPBackend(config Config, nw network) *httpBackend {
+	b := &httpBackend{
+		targets:      make(map[string]httpTarget),
+		headers:      config.HTTPHeaders,
+		uploadMethod: config.HTTPUploadMethod,
+		uploadSize:   config.HTTPUploadSize,
+		client:       nw.httpClient(),
+		streams:      httpDefaultStreams,
+	}
+
+	// Upload URLs are paired with download URLs by position, a single
+	// URL of either kind is shared by all targets
+	count := max(len(config.HTTPDownloadURLs), len(config.HTTPUploadURLs))
+	for i := 0; i < count; i++ {
+		target := httpTarget{
+			download: pairedURL(config.HTTPDownloadURLs, i),
+			upload:   pairedURL(config.HTTPUploadURLs, i),
+		}
+		id := target.download
+		if id == "" {
+			id = target.upload
+		}
+		// Credentials in the URL stay with the target, the ID ends up in
+		// metrics, logs and state files
+		if u, err := url.Parse(id); err == nil {
+			u.User = nil
+			id = u.String()
+		}
+		if _, ok := b.targets[id]; !ok {
+			b.order = append(b.order, id)
+		}
+		b.targets[id] = target
+	}
+
+	return b
+}
+
+// pairedURL returns the URL at index i, the only URL if there is just one, or an empty string
+func pairedURL(urls []string, i int) string {
+	switch {
+	case i < len(urls):
+		return urls[i]
+	case len(urls) == 1:
+		return urls[0]
+	default:
+		return ""
+	}
+}
+
+func (b *httpBackend) Name() string {
+	return string(BackendHTTP)
+}
+
+// Servers returns one server per target, identified by its download URL
+// (or upload URL for upload-only targets) without credentials
+func (b *httpBackend) Servers(_ context.Context) ([]*Server, error) {
+	servers := make([]*Server, 0, len(b.order))
+	for _, id := range b.order {
+		u, err := url.Parse(id)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid HTTP target URL '%s'", id)
+		}
+
+		servers = append(servers, &Server{
+			ID:   id,
+			Name: u.Host + u.Path,
+			Host: u.Host,
+			URL:  id,
+		})
+	}
+
+	return servers, nil
+}
+
+// Ping measures the round trip time of HEAD requests to the target.
+// A first request sets up the connection and is not counted.
+func (b *httpBackend) Ping(ctx context.Context, server *Server, count int) ([]time.Duration, error) {
+	if _, err := b.ping(ctx, server); err != nil {
+		return nil, err
+	}
+
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		latency, err := b.ping(ctx, server)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, latency)
+	}
+
+	return samples, nil
+}
+
+// ping sends a single HEAD request, any response counts since only the round trip matters
+func (b *httpBackend) ping(ctx context.Context, server *Server) (time.Duration, error) {
+	req, err := b.newRequest(ctx, http.MethodHead, b.targets[server.ID].pingURL(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return time.Since(start), nil
+}
+
+func (b *httpBackend) Download(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	downloadURL := b.targets[server.ID].download
+	if downloadURL == "" {
+		return Transfer{}, fmt.Errorf("no download URL configured for %s", server.ID)
+	}
+
+	// Each stream transfers the URL once, unless a duration asks for repeating it
+	opts = opts.withDefaults(0, b.streams)
+	return measureHTTP(ctx, opts.Streams, opts.Duration, func(ctx context.Context, progress *httpProgress) error {
+		req, err := b.newRequest(ctx, http.MethodGet, downloadURL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("download failed with status %s", resp.Status)
+		}
+		progress.gotFirstByte()
+
+		return drainBody(resp.Body, &progress.bytes)
+	})
+}
+
+func (b *httpBackend) Upload(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	uploadURL := b.targets[server.ID].upload
+	if uploadURL == "" {
+		return Transfer{}, fmt.Errorf("no upload URL configured for %s", server.ID)
+	}
+
+	// Each stream transfers the URL once, unless a duration asks for repeating it
+	opts = opts.withDefaults(0, b.streams)
+	return measureHTTP(ctx, opts.Streams, opts.Duration, func(ctx context.Context, progress *httpProgress) error {
+		req, err := b.newRequest(ctx, b.uploadMethod, uploadURL, newPayloadReader(b.uploadSize, &progress.bytes))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = b.uploadSize
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("upload failed with status %s", resp.Status)
+		}
+
+		return nil
+	})
+}
+
+// newRequest creates a request carrying the configured headers
+func (b *httpBackend) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range b.headers {
+		req.Header.Set(key, value)
+	}
+
+	return req, nil
+}
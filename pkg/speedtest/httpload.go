@@ -0,0 +1,130 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// httpChunkSize is the size of a single download or upload request
+const httpChunkSize = 25 * 1000 * 1000
+
+// httpTransfer performs one HTTP transfer, reporting the bytes moved as they flow
+type httpTransfer func(ctx context.Context, progress *httpProgress) error
+
+// httpProgress tracks the bytes moved by all streams of a measurement
+type httpProgress struct {
+	start     time.Time
+	bytes     atomic.Int64
+	firstByte atomic.Int64
+}
+
+// gotFirstByte records the time to first byte, only the earliest response across all streams counts
+func (p *httpProgress) gotFirstByte() {
+	p.firstByte.CompareAndSwap(0, int64(max(time.Since(p.start), 1)))
+}
+
+// measureHTTP runs transfers back to back on parallel streams for the given
+// duration and returns the measured transfer. Transfers still running when
+// the duration is over are cut off, the bytes they moved so far still count.
+// Without a duration every stream performs exactly one complete transfer.
+func measureHTTP(ctx context.Context, streams int, duration time.Duration, transfer httpTransfer) (Transfer, error) {
+	loadCtx, cancel := context.WithCancel(ctx)
+	if duration > 0 {
+		loadCtx, cancel = context.WithTimeout(ctx, duration)
+	}
+	defer cancel()
+
+	progress := &httpProgress{start: time.Now()}
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for loadCtx.Err() == nil {
+				if err := transfer(loadCtx, progress); err != nil && loadCtx.Err() == nil {
+					errOnce.Do(func() { firstErr = err })
+					cancel()
+					return
+				}
+				if duration <= 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(progress.start)
+
+	if err := ctx.Err(); err != nil {
+		return Transfer{}, err
+	}
+	if firstErr != nil {
+		return Transfer{}, firstErr
+	}
+	if elapsed <= 0 {
+		return Transfer{}, errors.New("no data transferred")
+	}
+
+	bytes := progress.bytes.Load()
+	return Transfer{
+		Mbps:     float64(bytes) * 8 / elapsed.Seconds() / 1e6,
+		Bytes:    bytes,
+		TTFB:     time.Duration(progress.firstByte.Load()),
+		Duration: elapsed,
+	}, nil
+}
+
+// drainBody reads the response body to the end, counting the bytes read
+func drainBody(body io.Reader, counter *atomic.Int64) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := body.Read(buf)
+		counter.Add(int64(n))
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// uploadPayload is a block of random bytes upload bodies are built from
+var uploadPayload = func() []byte {
+	block := make([]byte, 1<<20)
+	_, _ = rand.Read(block)
+	return block
+}()
+
+// payloadReader produces size bytes of random payload, counting the bytes read
+type payloadReader struct {
+	remaining int64
+	offset    int
+	counter   *atomic.Int64
+}
+
+func newPayloadReader(size int64, counter *atomic.Int64) *payloadReader {
+	return &payloadReader{remaining: size, counter: counter}
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	if p.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, uploadPayload[p.offset:])
+	n = int(min(int64(n), p.remaining))
+	p.offset = (p.offset + n) % len(uploadPayload)
+	p.remaining -= int64(n)
+	p.counter.Add(int64(n))
+
+	return n, nil
+}
@@ -0,0 +1,181 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HTTPTiming is the breakdown of a single request to a timing target. Stages
+// the request did not go through, e.g. TLS for plain HTTP, stay zero.
+type HTTPTiming struct {
+	Target     string
+	Interface  string
+	IPFamily   string
+	Proxy      string
+	Sample     int
+	DNS        time.Duration
+	Connect    time.Duration
+	TLS        time.Duration
+	TTFB       time.Duration
+	Total      time.Duration
+	StatusCode int
+	// Error is the failure reason, empty if the request completed
+	Error string
+}
+
+// runHTTPTimingTest requests every timing target HTTPTimingSamples times over
+// fresh connections, so every request pays for DNS, connect and TLS like a
+// first page visit. Failed requests are reported, not returned.
+func (r *Runner) runHTTPTimingTest(ctx context.Context) []HTTPTiming {
+	if len(r.config.HTTPTimingURLs) == 0 {
+		return nil
+	}
+
+	// Every network requests the targets, proxy paths included
+	var timings []HTTPTiming
+	for _, nw := range r.distinctNetworks(true) {
+		client := nw.httpClient()
+		transport := client.Transport.(*http.Transport)
+		transport.DisableKeepAlives = true
+		transport.DisableCompression = false
+		client.Timeout = r.config.Timeout
+
+		for _, target := range r.config.HTTPTimingURLs {
+			timings = append(timings, r.runHTTPTimingTarget(ctx, nw, client, target)...)
+		}
+	}
+
+	return timings
+}
+
+// runHTTPTimingTarget requests a single target one sample after another
+func (r *Runner) runHTTPTimingTarget(ctx context.Context, nw network, client *http.Client, target string) []HTTPTiming {
+	ctx, span := tracer.Start(ctx, "speedtest.http_timing_test")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("http_timing.target", target),
+		attribute.Int("http_timing.samples", r.config.HTTPTimingSamples),
+		attribute.String("interface", nw.iface),
+		attribute.String("ip_family", nw.family.label()),
+		attribute.String("proxy", nw.proxyFor(&Server{URL: target})),
+	)
+
+	timings := make([]HTTPTiming, 0, r.config.HTTPTimingSamples)
+	var failures int
+	for i := 0; i < r.config.HTTPTimingSamples; i++ {
+		timing := timeHTTPRequest(ctx, client, target)
+		timing.Sample = i + 1
+		timing.Interface = nw.iface
+		timing.IPFamily = nw.family.label()
+		timing.Proxy = nw.proxyFor(&Server{URL: target})
+
+		event := []attribute.KeyValue{
+			attribute.Int("http_timing.sample", timing.Sample),
+			attribute.Int64("http_timing.dns_nanos", timing.DNS.Nanoseconds()),
+			attribute.Int64("http_timing.connect_nanos", timing.Connect.Nanoseconds()),
+			attribute.Int64("http_timing.tls_nanos", timing.TLS.Nanoseconds()),
+			attribute.Int64("http_timing.ttfb_nanos", timing.TTFB.Nanoseconds()),
+			attribute.Int64("http_timing.total_nanos", timing.Total.Nanoseconds()),
+			attribute.Int("http_timing.status_code", timing.StatusCode),
+		}
+		if timing.Error != "" {
+			failures++
+			event = append(event, attribute.String("http_timing.error", timing.Error))
+		}
+		span.AddEvent("http.request", trace.WithAttributes(event...))
+		timings = append(timings, timing)
+	}
+
+	span.SetAttributes(attribute.Int("http_timing.failures", failures))
+	if failures == len(timings) {
+		span.SetStatus(codes.Error, "all requests failed")
+	} else {
+		span.SetStatus(codes.Ok, "http timing test completed")
+	}
+
+	return timings
+}
+
+// timeHTTPRequest fetches the target and measures the stages of the request
+// with httptrace. TTFB and total are measured from the start of the request.
+func timeHTTPRequest(ctx context.Context, client *http.Client, target string) HTTPTiming {
+	timing := HTTPTiming{Target: target}
+
+	// Parallel dials to several addresses run the hooks concurrently
+	var mu sync.Mutex
+	var dnsStart, connectStart, tlsStart time.Time
+	clientTrace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !dnsStart.IsZero() {
+				timing.DNS = time.Since(dnsStart)
+			}
+		},
+		// With several addresses only the first connection that succeeded counts
+		ConnectStart: func(_, _ string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil && timing.Connect == 0 {
+				timing.Connect = time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				timing.TLS = time.Since(tlsStart)
+			}
+		},
+	}
+
+	start := time.Now()
+	clientTrace.GotFirstResponseByte = func() { timing.TTFB = time.Since(start) }
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, clientTrace), http.MethodGet, target, nil)
+	if err != nil {
+		timing.Error = err.Error()
+		return timing
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+		timing.Total = time.Since(start)
+		timing.Error = err.Error()
+		return timing
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(io.Discard, resp.Body)
+
+	mu.Lock()
+	defer mu.Unlock()
+	timing.Total = time.Since(start)
+	timing.StatusCode = resp.StatusCode
+	switch {
+	case err != nil:
+		timing.Error = fmt.Sprintf("failed to read response: %v", err)
+	case resp.StatusCode >= http.StatusBadRequest:
+		timing.Error = fmt.Sprintf("request failed with status %s", resp.Status)
+	}
+
+	return timing
+}
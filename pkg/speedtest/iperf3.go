@@ -0,0 +1,466 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	iperf3DefaultPort     = "5201"
+	iperf3DefaultDuration = 10 * time.Second
+	iperf3DefaultStreams  = 1
+	iperf3BlockSize       = 128 * 1024
+	iperf3CookieSize      = 37
+	iperf3ClientVersion   = "3.16"
+
+	// iperf3ResultGrace bounds how long the server may take to wrap up a test
+	iperf3ResultGrace = 15 * time.Second
+)
+
+// iperf3 control protocol states, as sent on the control connection
+const (
+	iperf3TestStart       int8 = 1
+	iperf3TestRunning     int8 = 2
+	iperf3TestEnd         int8 = 4
+	iperf3ParamExchange   int8 = 9
+	iperf3CreateStreams   int8 = 10
+	iperf3ServerTerminate int8 = 11
+	iperf3ExchangeResults int8 = 13
+	iperf3DisplayResults  int8 = 14
+	iperf3Done            int8 = 16
+	iperf3AccessDenied    int8 = -1
+	iperf3ServerError     int8 = -2
+)
+
+// iperf3Params are the test parameters sent to the server
+type iperf3Params struct {
+	TCP           bool   `json:"tcp"`
+	Omit          int    `json:"omit"`
+	Time          int    `json:"time"`
+	Num           int    `json:"num"`
+	BlockCount    int    `json:"blockcount"`
+	Parallel      int    `json:"parallel"`
+	Reverse       bool   `json:"reverse,omitempty"`
+	Len           int    `json:"len"`
+	PacingTimer   int    `json:"pacing_timer"`
+	ClientVersion string `json:"client_version"`
+}
+
+// iperf3Results are the per-stream results exchanged after a test
+type iperf3Results struct {
+	CPUUtilTotal         float64              `json:"cpu_util_total"`
+	CPUUtilUser          float64              `json:"cpu_util_user"`
+	CPUUtilSystem        float64              `json:"cpu_util_system"`
+	SenderHasRetransmits int                  `json:"sender_has_retransmits"`
+	Streams              []iperf3StreamResult `json:"streams"`
+}
+
+type iperf3StreamResult struct {
+	ID          int     `json:"id"`
+	Bytes       int64   `json:"bytes"`
+	Retransmits int     `json:"retransmits"`
+	Jitter      float64 `json:"jitter"`
+	Errors      int     `json:"errors"`
+	Packets     int     `json:"packets"`
+	StartTime   float64 `json:"start_time"`
+	EndTime     float64 `json:"end_time"`
+}
+
+// iperf3Backend measures against iperf3 servers using the iperf3 control protocol over TCP.
+// Uploads run in normal mode (client sends), downloads in reverse mode (server sends).
+type iperf3Backend struct {
+	network  network
+	hosts    []string
+	timeout  time.Duration
+	duration time.Duration
+	streams  int
+}
+
+func newIperf3Backend(config Config, nw network) *iperf3Backend {
+	return &iperf3Backend{
+		network:  nw,
+		hosts:    config.Iperf3Servers,
+		timeout:  config.Timeout,
+		duration: iperf3DefaultDuration,
+		streams:  iperf3DefaultStreams,
+	}
+}
+
+func (b *iperf3Backend) Name() string {
+	return string(BackendIperf3)
+}
+
+// Servers returns the configured iperf3 servers, identified by host:port
+func (b *iperf3Backend) Servers(_ context.Context) ([]*Server, error) {
+	servers := make([]*Server, 0, len(b.hosts))
+	for _, host := range b.hosts {
+		address := iperf3Address(host)
+		name, _, _ := net.SplitHostPort(address)
+		servers = append(servers, &Server{
+			ID:   address,
+			Name: name,
+			Host: address,
+		})
+	}
+
+	return servers, nil
+}
+
+// Ping measures the TCP connect time to the iperf3 control port
+func (b *iperf3Backend) Ping(ctx context.Context, server *Server, count int) ([]time.Duration, error) {
+	dial, err := b.network.dialContext(b.timeout)
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]time.Duration, 0, count)
+
+	var lastErr error
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(rankingPingInterval):
+			}
+		}
+
+		start := time.Now()
+		conn, err := dial(ctx, "tcp", server.Host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		samples = append(samples, time.Since(start))
+		conn.Close()
+	}
+
+	if len(samples) == 0 {
+		return nil, lastErr
+	}
+
+	return samples, nil
+}
+
+func (b *iperf3Backend) Download(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	return b.run(ctx, server, opts.withDefaults(b.duration, b.streams), true)
+}
+
+func (b *iperf3Backend) Upload(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	return b.run(ctx, server, opts.withDefaults(b.duration, b.streams), false)
+}
+
+// run performs a single iperf3 TCP test and returns the transfer it measured.
+// In reverse mode the server sends and the client counts the received bytes,
+// otherwise the client sends and the server reports the received bytes.
+func (b *iperf3Backend) run(ctx context.Context, server *Server, opts TransferOptions, reverse bool) (Transfer, error) {
+	dial, err := b.network.dialContext(b.timeout)
+	if err != nil {
+		return Transfer{}, err
+	}
+
+	control, err := dial(ctx, "tcp", server.Host)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("failed to connect to iperf3 server: %w", err)
+	}
+	defer control.Close()
+
+	// Bound the whole exchange and abort it when the context is cancelled
+	deadline := time.Now().Add(b.timeout + opts.Duration + iperf3ResultGrace)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = control.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = control.SetDeadline(time.Now()) })
+	defer stop()
+
+	cookie, err := iperf3Cookie()
+	if err != nil {
+		return Transfer{}, err
+	}
+	if _, err := control.Write(cookie); err != nil {
+		return Transfer{}, fmt.Errorf("failed to send cookie: %w", err)
+	}
+
+	if err := iperf3Expect(control, iperf3ParamExchange); err != nil {
+		return Transfer{}, err
+	}
+
+	params := iperf3Params{
+		TCP:           true,
+		Time:          max(int(opts.Duration.Round(time.Second).Seconds()), 1),
+		Parallel:      opts.Streams,
+		Reverse:       reverse,
+		Len:           iperf3BlockSize,
+		PacingTimer:   1000,
+		ClientVersion: iperf3ClientVersion,
+	}
+	if err := iperf3WriteJSON(control, params); err != nil {
+		return Transfer{}, fmt.Errorf("failed to send test parameters: %w", err)
+	}
+
+	if err := iperf3Expect(control, iperf3CreateStreams); err != nil {
+		return Transfer{}, err
+	}
+
+	streams := make([]net.Conn, 0, opts.Streams)
+	defer func() {
+		for _, stream := range streams {
+			stream.Close()
+		}
+	}()
+	for i := 0; i < opts.Streams; i++ {
+		stream, err := dial(ctx, "tcp", server.Host)
+		if err != nil {
+			return Transfer{}, fmt.Errorf("failed to open data stream: %w", err)
+		}
+		streams = append(streams, stream)
+		if _, err := stream.Write(cookie); err != nil {
+			return Transfer{}, fmt.Errorf("failed to send data stream cookie: %w", err)
+		}
+	}
+
+	// The server announces TEST_START before TEST_RUNNING
+	if err := iperf3Expect(control, iperf3TestStart); err != nil {
+		return Transfer{}, err
+	}
+	if err := iperf3Expect(control, iperf3TestRunning); err != nil {
+		return Transfer{}, err
+	}
+
+	start := time.Now()
+	end := start.Add(opts.Duration)
+	transferred := make([]int64, len(streams))
+
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	for i, stream := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reverse {
+				transferred[i] = iperf3Receive(stream, &done)
+			} else {
+				transferred[i] = iperf3Send(stream, end)
+			}
+		}()
+	}
+
+	if reverse {
+		// The client ends the test in both directions; keep draining afterwards
+		select {
+		case <-ctx.Done():
+		case <-time.After(opts.Duration):
+		}
+		done.Store(true)
+	} else {
+		wg.Wait()
+	}
+	elapsed := time.Since(start)
+
+	if err := ctx.Err(); err != nil {
+		return Transfer{}, err
+	}
+
+	if err := iperf3WriteState(control, iperf3TestEnd); err != nil {
+		return Transfer{}, fmt.Errorf("failed to end test: %w", err)
+	}
+
+	if err := iperf3Expect(control, iperf3ExchangeResults); err != nil {
+		return Transfer{}, err
+	}
+
+	if reverse {
+		// Stop the receivers so the byte counts are final
+		for _, stream := range streams {
+			_ = stream.SetReadDeadline(time.Now())
+		}
+		wg.Wait()
+	}
+
+	clientResults := iperf3Results{Streams: make([]iperf3StreamResult, len(streams))}
+	for i := range streams {
+		clientResults.Streams[i] = iperf3StreamResult{
+			ID:          iperf3StreamID(i),
+			Bytes:       transferred[i],
+			Retransmits: -1,
+			EndTime:     elapsed.Seconds(),
+		}
+	}
+	if err := iperf3WriteJSON(control, clientResults); err != nil {
+		return Transfer{}, fmt.Errorf("failed to send results: %w", err)
+	}
+
+	var serverResults iperf3Results
+	if err := iperf3ReadJSON(control, &serverResults); err != nil {
+		return Transfer{}, fmt.Errorf("failed to read server results: %w", err)
+	}
+
+	if err := iperf3Expect(control, iperf3DisplayResults); err != nil {
+		return Transfer{}, err
+	}
+	_ = iperf3WriteState(control, iperf3Done)
+
+	// Prefer the byte count of the receiving side
+	var bytes int64
+	seconds := elapsed.Seconds()
+	if reverse {
+		for _, n := range transferred {
+			bytes += n
+		}
+	} else {
+		for _, stream := range serverResults.Streams {
+			bytes += stream.Bytes
+			seconds = max(seconds, stream.EndTime)
+		}
+	}
+
+	if seconds <= 0 {
+		return Transfer{}, errors.New("iperf3 test did not run")
+	}
+
+	return Transfer{
+		Mbps:     float64(bytes) * 8 / seconds / 1e6,
+		Bytes:    bytes,
+		Duration: time.Duration(seconds * float64(time.Second)),
+	}, nil
+}
+
+// iperf3Send writes blocks to the stream until end and returns the bytes written
+func iperf3Send(stream net.Conn, end time.Time) int64 {
+	block := make([]byte, iperf3BlockSize)
+	_ = stream.SetWriteDeadline(end)
+
+	var total int64
+	for {
+		n, err := stream.Write(block)
+		total += int64(n)
+		if err != nil {
+			return total
+		}
+	}
+}
+
+// iperf3Receive reads from the stream until it fails and returns the bytes
+// read before done was set
+func iperf3Receive(stream net.Conn, done *atomic.Bool) int64 {
+	buf := make([]byte, iperf3BlockSize)
+
+	var total int64
+	for {
+		n, err := stream.Read(buf)
+		if !done.Load() {
+			total += int64(n)
+		}
+		if err != nil {
+			return total
+		}
+	}
+}
+
+// iperf3Address adds the default iperf3 port to host if it has none
+func iperf3Address(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, iperf3DefaultPort)
+}
+
+// iperf3StreamID returns the stream ID iperf3 assigns to the i-th stream
+func iperf3StreamID(i int) int {
+	if i == 0 {
+		return 1
+	}
+	return i + 2
+}
+
+// iperf3Cookie generates the NUL-terminated session cookie
+func iperf3Cookie() ([]byte, error) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+	cookie := make([]byte, iperf3CookieSize)
+	if _, err := rand.Read(cookie[:iperf3CookieSize-1]); err != nil {
+		return nil, fmt.Errorf("failed to generate cookie: %w", err)
+	}
+	for i := 0; i < iperf3CookieSize-1; i++ {
+		cookie[i] = alphabet[int(cookie[i])%len(alphabet)]
+	}
+	cookie[iperf3CookieSize-1] = 0
+
+	return cookie, nil
+}
+
+// iperf3Expect reads the next state from the control connection and fails
+// unless it matches the expected one
+func iperf3Expect(control net.Conn, expected int8) error {
+	var state [1]byte
+	if _, err := io.ReadFull(control, state[:]); err != nil {
+		return fmt.Errorf("failed to read iperf3 state: %w", err)
+	}
+
+	switch got := int8(state[0]); got {
+	case expected:
+		return nil
+	case iperf3AccessDenied:
+		return errors.New("iperf3 server denied access, it is probably busy")
+	case iperf3ServerError:
+		var codes [8]byte
+		if _, err := io.ReadFull(control, codes[:]); err != nil {
+			return errors.New("iperf3 server error")
+		}
+		return fmt.Errorf("iperf3 server error %d (errno %d)",
+			int32(binary.BigEndian.Uint32(codes[:4])), int32(binary.BigEndian.Uint32(codes[4:])))
+	case iperf3ServerTerminate:
+		return errors.New("iperf3 server terminated the test")
+	default:
+		return fmt.Errorf("unexpected iperf3 state %d, expected %d", got, expected)
+	}
+}
+
+func iperf3WriteState(control net.Conn, state int8) error {
+	_, err := control.Write([]byte{byte(state)})
+	return err
+}
+
+// iperf3WriteJSON sends a length-prefixed JSON message
+func iperf3WriteJSON(control net.Conn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	msg := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(msg, uint32(len(data)))
+	copy(msg[4:], data)
+
+	_, err = control.Write(msg)
+	return err
+}
+
+// iperf3ReadJSON reads a length-prefixed JSON message
+func iperf3ReadJSON(control net.Conn, v any) error {
+	var size [4]byte
+	if _, err := io.ReadFull(control, size[:]); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(size[:])
+	if length > 1<<20 {
+		return fmt.Errorf("iperf3 message too large (%d bytes)", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(control, data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
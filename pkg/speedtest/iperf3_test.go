@@ -0,0 +1,283 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// iperf3StandIn is a minimal iperf3 server speaking the server side of the
+// control protocol for a single test at a time. It records the parameters of
+// the last test and the bytes the data streams moved.
+type iperf3StandIn struct {
+	listener net.Listener
+
+	// busy makes the server deny access like an iperf3 server running another test
+	busy bool
+
+	mu     sync.Mutex
+	params iperf3Params
+	bytes  int64
+	err    error
+}
+
+// newIperf3StandIn starts the stand-in on a local port, stopped with the test
+func newIperf3StandIn(t *testing.T) *iperf3StandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &iperf3StandIn{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go s.serve()
+	return s
+}
+
+// server returns the stand-in as server of the iperf3 backend
+func (s *iperf3StandIn) server() *Server {
+	address := s.listener.Addr().String()
+	return &Server{ID: address, Name: "stand-in", Host: address}
+}
+
+// result returns the parameters and byte count of the last test and the
+// protocol error the stand-in ran into, if any
+func (s *iperf3StandIn) result() (iperf3Params, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.params, s.bytes, s.err
+}
+
+// serve accepts control connections. Connections that do not send a cookie,
+// like the ones of Ping, are dropped.
+func (s *iperf3StandIn) serve() {
+	for {
+		control, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		cookie := make([]byte, iperf3CookieSize)
+		_ = control.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(control, cookie); err != nil {
+			control.Close()
+			continue
+		}
+		_ = control.SetReadDeadline(time.Time{})
+
+		err = s.runTest(control)
+		control.Close()
+
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}
+}
+
+// runTest runs a test on the control connection, accepting the data streams
+// from the listener, and reports the bytes of the streams as the server's results
+func (s *iperf3StandIn) runTest(control net.Conn) error {
+	if s.busy {
+		return iperf3WriteState(control, iperf3AccessDenied)
+	}
+
+	if err := iperf3WriteState(control, iperf3ParamExchange); err != nil {
+		return err
+	}
+	var params iperf3Params
+	if err := iperf3ReadJSON(control, &params); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.params = params
+	s.mu.Unlock()
+
+	if err := iperf3WriteState(control, iperf3CreateStreams); err != nil {
+		return err
+	}
+	streams := make([]net.Conn, 0, params.Parallel)
+	defer func() {
+		for _, stream := range streams {
+			stream.Close()
+		}
+	}()
+	for range params.Parallel {
+		stream, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		streams = append(streams, stream)
+		if _, err := io.ReadFull(stream, make([]byte, iperf3CookieSize)); err != nil {
+			return err
+		}
+	}
+
+	if err := iperf3WriteState(control, iperf3TestStart); err != nil {
+		return err
+	}
+	if err := iperf3WriteState(control, iperf3TestRunning); err != nil {
+		return err
+	}
+
+	// Reverse tests send from the server, normal tests receive
+	var wg sync.WaitGroup
+	var done atomic.Bool
+	var total atomic.Int64
+	for _, stream := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if params.Reverse {
+				block := make([]byte, params.Len)
+				for !done.Load() {
+					n, err := stream.Write(block)
+					total.Add(int64(n))
+					if err != nil {
+						return
+					}
+				}
+				return
+			}
+			total.Add(iperf3Receive(stream, &done))
+		}()
+	}
+
+	if err := iperf3ExpectClient(control, iperf3TestEnd); err != nil {
+		return err
+	}
+	done.Store(true)
+	for _, stream := range streams {
+		// Let the receivers drain what is still in flight, and unblock the senders
+		_ = stream.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	}
+	wg.Wait()
+
+	if err := iperf3WriteState(control, iperf3ExchangeResults); err != nil {
+		return err
+	}
+	var clientResults iperf3Results
+	if err := iperf3ReadJSON(control, &clientResults); err != nil {
+		return err
+	}
+	if len(clientResults.Streams) != len(streams) {
+		return errors.New("client results do not cover every stream")
+	}
+
+	// The stand-in reports the bytes of all streams in the first one
+	serverResults := iperf3Results{Streams: []iperf3StreamResult{{ID: 1, Bytes: total.Load(), EndTime: float64(params.Time)}}}
+	s.mu.Lock()
+	s.bytes = total.Load()
+	s.mu.Unlock()
+	if err := iperf3WriteJSON(control, serverResults); err != nil {
+		return err
+	}
+
+	if err := iperf3WriteState(control, iperf3DisplayResults); err != nil {
+		return err
+	}
+
+	return iperf3ExpectClient(control, iperf3Done)
+}
+
+// iperf3ExpectClient reads the next state the client sends
+func iperf3ExpectClient(control net.Conn, expected int8) error {
+	var state [1]byte
+	if _, err := io.ReadFull(control, state[:]); err != nil {
+		return err
+	}
+	if int8(state[0]) != expected {
+		return errors.New("unexpected client state")
+	}
+
+	return nil
+}
+
+// testIperf3Backend returns a backend on the default network
+func testIperf3Backend() *iperf3Backend {
+	return newIperf3Backend(Config{Timeout: 5 * time.Second}, network{family: IPFamilyAuto})
+}
+
+func TestIperf3Ping(t *testing.T) {
+	standIn := newIperf3StandIn(t)
+
+	samples, err := testIperf3Backend().Ping(context.Background(), standIn.server(), 3)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	for _, sample := range samples {
+		if sample <= 0 {
+			t.Errorf("got non-positive sample %v", sample)
+		}
+	}
+}
+
+func TestIperf3Download(t *testing.T) {
+	standIn := newIperf3StandIn(t)
+
+	transfer, err := testIperf3Backend().Download(context.Background(), standIn.server(), TransferOptions{Duration: time.Second, Streams: 2})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	params, sent, err := standIn.result()
+	if err != nil {
+		t.Fatalf("stand-in failed: %v", err)
+	}
+	if !params.Reverse || params.Parallel != 2 || params.Time != 1 || !params.TCP {
+		t.Errorf("got parameters %+v, want a reverse TCP test of 1s with 2 streams", params)
+	}
+
+	// The client counts what it received, which the server sent
+	if transfer.Bytes <= 0 || transfer.Bytes > sent {
+		t.Errorf("got %d bytes, want between 1 and the %d bytes sent", transfer.Bytes, sent)
+	}
+	if transfer.Mbps <= 0 {
+		t.Errorf("got %.2f Mbps, want a positive throughput", transfer.Mbps)
+	}
+}
+
+func TestIperf3Upload(t *testing.T) {
+	standIn := newIperf3StandIn(t)
+
+	transfer, err := testIperf3Backend().Upload(context.Background(), standIn.server(), TransferOptions{Duration: time.Second, Streams: 1})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	params, received, err := standIn.result()
+	if err != nil {
+		t.Fatalf("stand-in failed: %v", err)
+	}
+	if params.Reverse || params.Parallel != 1 {
+		t.Errorf("got parameters %+v, want a normal test with 1 stream", params)
+	}
+
+	// Uploads report the byte count of the receiving server
+	if transfer.Bytes != received || received <= 0 {
+		t.Errorf("got %d bytes, want the %d bytes the server received", transfer.Bytes, received)
+	}
+	if transfer.Mbps <= 0 {
+		t.Errorf("got %.2f Mbps, want a positive throughput", transfer.Mbps)
+	}
+}
+
+func TestIperf3Busy(t *testing.T) {
+	standIn := newIperf3StandIn(t)
+	standIn.busy = true
+
+	_, err := testIperf3Backend().Download(context.Background(), standIn.server(), TransferOptions{Duration: time.Second})
+	if err == nil || err.Error() != "iperf3 server denied access, it is probably busy" {
+		t.Fatalf("got error %v, want access denied", err)
+	}
+}
@@ -0,0 +1,329 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LibreSpeedDefaultServerList is the server list of the public LibreSpeed instances
+	LibreSpeedDefaultServerList = "https://librespeed.org/backend-servers/servers.php"
+
+	librespeedDefaultDuration = 10 * time.Second
+	librespeedDownloadStreams = 6
+	librespeedUploadStreams   = 3
+
+	// librespeedChunks is the number of 1 MiB chunks requested per download
+	librespeedChunks = 100
+
+	// librespeedUploadSize matches the upload blob size of the LibreSpeed client
+	librespeedUploadSize = 20 * 1024 * 1024
+
+	librespeedEndpointDownload = "download"
+	librespeedEndpointUpload   = "upload"
+	librespeedEndpointPing     = "ping"
+	librespeedEndpointGetIP    = "getip"
+)
+
+// librespeedServerEntry is a server in a LibreSpeed server list
+type librespeedServerEntry struct {
+	ID       json.Number `json:"id"`
+	Name     string      `json:"name"`
+	Server   string      `json:"server"`
+	DLURL    string      `json:"dlURL"`
+	ULURL    string      `json:"ulURL"`
+	PingURL  string      `json:"pingURL"`
+	GetIPURL string      `json:"getIpURL"`
+}
+
+// librespeedIPInfo is the response of the getIP endpoint with ISP information
+type librespeedIPInfo struct {
+	ProcessedString string `json:"processedString"`
+	RawISPInfo      any    `json:"rawIspInfo"`
+}
+
+// librespeedBackend measures against LibreSpeed instances given by base URL
+type librespeedBackend struct {
+	baseURLs []string
+	client   *http.Client
+	duration time.Duration
+}
+
+// librespeedListBackend measures against the instances of a LibreSpeed server list
+type librespeedListBackend struct {
+	*librespeedBackend
+	serverListURL string
+}
+
+func newLibreSpeedBackend(config Config, nw network) Backend {
+	b := &librespeedBackend{
+		baseURLs: config.LibreSpeedServers,
+		client:   nw.httpClient(),
+		duration: librespeedDefaultDuration,
+	}
+	if len(config.LibreSpeedServers) > 0 {
+		return b
+	}
+
+	return &librespeedListBackend{librespeedBackend: b, serverListURL: config.LibreSpeedServerList}
+}
+
+func (b *librespeedBackend) Name() string {
+	return string(BackendLibreSpeed)
+}
+
+// Servers returns the configured instances, identified by their base URL and
+// using the default LibreSpeed backend endpoints
+func (b *librespeedBackend) Servers(_ context.Context) ([]*Server, error) {
+	servers := make([]*Server, 0, len(b.baseURLs))
+	for _, baseURL := range b.baseURLs {
+		server, err := librespeedServer(librespeedServerEntry{
+			ID:       json.Number(strings.TrimSuffix(baseURL, "/")),
+			Server:   baseURL,
+			DLURL:    "garbage.php",
+			ULURL:    "empty.php",
+			PingURL:  "empty.php",
+			GetIPURL: "getIP.php",
+		})
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+func (b *librespeedListBackend) remoteServerList() {}
+
+// Servers fetches the LibreSpeed server list
+func (b *librespeedListBackend) Servers(ctx context.Context) ([]*Server, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.serverListURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server list request failed with status %s", resp.Status)
+	}
+
+	var entries []librespeedServerEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to parse server list: %w", err)
+	}
+
+	servers := make([]*Server, 0, len(entries))
+	for _, entry := range entries {
+		server, err := librespeedServer(entry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Skipping LibreSpeed server %s: %v\n", entry.ID, err)
+			continue
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+// librespeedServer converts a server list entry, resolving its endpoint URLs
+func librespeedServer(entry librespeedServerEntry) (*Server, error) {
+	base := entry.Server
+	// Public lists use protocol-relative URLs meant for browsers
+	if strings.HasPrefix(base, "//") {
+		base = "https:" + base
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid LibreSpeed server URL '%s'", entry.Server)
+	}
+
+	name := entry.Name
+	if name == "" {
+		name = u.Hostname()
+	}
+
+	return &Server{
+		ID:   entry.ID.String(),
+		Name: name,
+		Host: u.Host,
+		URL:  base,
+		Endpoints: map[string]string{
+			librespeedEndpointDownload: base + entry.DLURL,
+			librespeedEndpointUpload:   base + entry.ULURL,
+			librespeedEndpointPing:     base + entry.PingURL,
+			librespeedEndpointGetIP:    base + entry.GetIPURL,
+		},
+	}, nil
+}
+
+// librespeedURL returns the endpoint URL with the given query and a cache buster
+func librespeedURL(server *Server, endpoint string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("r", strconv.FormatFloat(rand.Float64(), 'f', -1, 64))
+
+	target := server.Endpoints[endpoint]
+	separator := "?"
+	if strings.Contains(target, "?") {
+		separator = "&"
+	}
+
+	return target + separator + query.Encode()
+}
+
+// Ping measures the HTTP round trip time of the empty endpoint.
+// A first request sets up the connection and is not counted.
+func (b *librespeedBackend) Ping(ctx context.Context, server *Server, count int) ([]time.Duration, error) {
+	if _, err := b.ping(ctx, server); err != nil {
+		return nil, err
+	}
+
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		latency, err := b.ping(ctx, server)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, latency)
+	}
+
+	return samples, nil
+}
+
+func (b *librespeedBackend) ping(ctx context.Context, server *Server) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, librespeedURL(server, librespeedEndpointPing, nil), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return 0, fmt.Errorf("ping failed with status %s", resp.Status)
+	}
+
+	return latency, nil
+}
+
+func (b *librespeedBackend) Download(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	opts = opts.withDefaults(b.duration, librespeedDownloadStreams)
+	return measureHTTP(ctx, opts.Streams, opts.Duration, func(ctx context.Context, progress *httpProgress) error {
+		query := url.Values{"ckSize": {strconv.Itoa(librespeedChunks)}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, librespeedURL(server, librespeedEndpointDownload, query), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("download failed with status %s", resp.Status)
+		}
+		progress.gotFirstByte()
+
+		return drainBody(resp.Body, &progress.bytes)
+	})
+}
+
+func (b *librespeedBackend) Upload(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	opts = opts.withDefaults(b.duration, librespeedUploadStreams)
+	return measureHTTP(ctx, opts.Streams, opts.Duration, func(ctx context.Context, progress *httpProgress) error {
+		body := newPayloadReader(librespeedUploadSize, &progress.bytes)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, librespeedURL(server, librespeedEndpointUpload, nil), body)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = librespeedUploadSize
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("upload failed with status %s", resp.Status)
+		}
+
+		return nil
+	})
+}
+
+// clientInfo asks the getIP endpoint for the public IP address and ISP of the client
+func (b *librespeedBackend) clientInfo(ctx context.Context, server *Server) (ClientInfo, error) {
+	query := url.Values{"isp": {"true"}, "distance": {"km"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, librespeedURL(server, librespeedEndpointGetIP, query), nil)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ClientInfo{}, fmt.Errorf("getIP failed with status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return ClientInfo{}, err
+	}
+
+	// With ISP lookup the endpoint answers with JSON, otherwise with the plain IP address
+	var info librespeedIPInfo
+	if err := json.Unmarshal(body, &info); err != nil || info.ProcessedString == "" {
+		return ClientInfo{IP: strings.TrimSpace(string(body))}, nil
+	}
+
+	// The processed string reads "1.2.3.4 - Deutsche Telekom AG, DE (12 km)"
+	ip, isp, _ := strings.Cut(info.ProcessedString, " - ")
+	isp, _, _ = strings.Cut(isp, ",")
+	client := ClientInfo{IP: strings.TrimSpace(ip), ISP: strings.TrimSpace(isp)}
+
+	// The raw ipinfo.io answer carries the ASN as "org": "AS3320 Deutsche Telekom AG"
+	if raw, ok := info.RawISPInfo.(map[string]any); ok {
+		if org, ok := raw["org"].(string); ok {
+			if asn, name := parseASOrganization(org); asn != 0 {
+				client.ASN, client.ISP = asn, name
+			}
+		}
+	}
+
+	return client, nil
+}
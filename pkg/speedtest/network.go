@@ -0,0 +1,371 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// networkDialTimeout matches the dial timeout of the default HTTP transport
+const networkDialTimeout = 30 * time.Second
+
+const (
+	// proxyPathDirect labels the direct run of a proxy comparison
+	proxyPathDirect = "direct"
+
+	// proxyPathProxy labels the proxied run of a proxy comparison
+	proxyPathProxy = "proxy"
+)
+
+// errProxyUnsupported is returned by measurements that cannot pass through the proxy
+var errProxyUnsupported = errors.New("not supported through a proxy")
+
+// IPFamily selects the IP version connections use
+type IPFamily string
+
+const (
+	// IPFamilyAuto lets the OS choose, usually IPv6 where available
+	IPFamilyAuto IPFamily = "auto"
+
+	// IPFamilyIPv4 connects over IPv4 only
+	IPFamilyIPv4 IPFamily = "ipv4"
+
+	// IPFamilyIPv6 connects over IPv6 only
+	IPFamilyIPv6 IPFamily = "ipv6"
+
+	// IPFamilyBoth measures every server over IPv4 and over IPv6
+	IPFamilyBoth IPFamily = "both"
+)
+
+// Valid checks if the IP family is valid
+func (f IPFamily) Valid() bool {
+	switch f {
+	case IPFamilyAuto, IPFamilyIPv4, IPFamilyIPv6, IPFamilyBoth:
+		return true
+	default:
+		return false
+	}
+}
+
+// families returns the families measured one after another
+func (f IPFamily) families() []IPFamily {
+	if f == IPFamilyBoth {
+		return []IPFamily{IPFamilyIPv4, IPFamilyIPv6}
+	}
+
+	return []IPFamily{f}
+}
+
+// matches reports whether the IP address belongs to the family
+func (f IPFamily) matches(ip net.IP) bool {
+	switch f {
+	case IPFamilyIPv4:
+		return ip.To4() != nil
+	case IPFamilyIPv6:
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+// label returns the value of the ip_family label, empty when the OS chooses
+func (f IPFamily) label() string {
+	if f == IPFamilyAuto {
+		return ""
+	}
+
+	return string(f)
+}
+
+// network describes how a backend connects to its servers
+type network struct {
+	// iface is the interface name or local address as configured, empty without binding
+	iface string
+
+	// source is the local address connections originate from, nil lets the OS choose
+	source net.IP
+
+	// family restricts connections to IPv4 or IPv6
+	family IPFamily
+
+	// proxy is the explicitly configured proxy, nil falls back to HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	proxy *url.URL
+
+	// direct bypasses any proxy, including the one from the environment
+	direct bool
+}
+
+// key identifies the network in state files: the interface, IP family and
+// proxy, without the proxy credentials
+func (n network) key() string {
+	key := n.iface + "/" + string(n.family)
+	switch {
+	case n.direct:
+		key += "/direct"
+	case n.proxy != nil:
+		key += "/" + n.proxy.Host
+	}
+
+	return key
+}
+
+// newNetwork binds connections to the given interface name or local IP address
+// and IP family, an empty iface leaves the choice of the local address to the OS
+func newNetwork(iface string, family IPFamily) (network, error) {
+	if iface == "" {
+		return network{family: family}, nil
+	}
+
+	source, err := resolveSource(iface, family)
+	if err != nil {
+		return network{}, err
+	}
+
+	return network{iface: iface, source: source, family: family}, nil
+}
+
+// resolveSource returns the local address of the interface in the family,
+// preferring IPv4 over IPv6 and global over link-local addresses. IP addresses
+// are used as-is.
+func resolveSource(iface string, family IPFamily) (net.IP, error) {
+	if ip := net.ParseIP(iface); ip != nil {
+		if !family.matches(ip) {
+			return nil, fmt.Errorf("source address %s is not an %s address", iface, family)
+		}
+		return ip, nil
+	}
+
+	netInterface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("unknown interface '%s': %w", iface, err)
+	}
+	addrs, err := netInterface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of interface '%s': %w", iface, err)
+	}
+
+	var candidates []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() && family.matches(ipNet.IP) {
+			candidates = append(candidates, ipNet.IP)
+		}
+	}
+	for _, ip := range candidates {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0], nil
+	}
+
+	if family != IPFamilyAuto {
+		return nil, fmt.Errorf("interface '%s' has no usable %s address", iface, family)
+	}
+	return nil, fmt.Errorf("interface '%s' has no usable address", iface)
+}
+
+// control rejects connections outside of the IP family, the dialer then moves
+// on to the next address of the host
+func (n network) control(network, address string, _ syscall.RawConn) error {
+	switch {
+	case n.family == IPFamilyIPv4 && !strings.HasSuffix(network, "4"):
+		return fmt.Errorf("%s is not an IPv4 address", address)
+	case n.family == IPFamilyIPv6 && !strings.HasSuffix(network, "6"):
+		return fmt.Errorf("%s is not an IPv6 address", address)
+	default:
+		return nil
+	}
+}
+
+// checkServer fails clearly when the server has no address in the IP family,
+// instead of leaving the backend with a cryptic dial error
+func (n network) checkServer(ctx context.Context, server *Server) error {
+	if n.family == IPFamilyAuto || server.Host == "" {
+		return nil
+	}
+
+	host := server.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		if !n.family.matches(ip) {
+			return fmt.Errorf("server %s is not reachable over %s", server.ID, n.family)
+		}
+		return nil
+	}
+
+	lookup := "ip4"
+	if n.family == IPFamilyIPv6 {
+		lookup = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, lookup, host)
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("server %s has no %s address (%s)", server.ID, n.family, host)
+	}
+
+	return nil
+}
+
+// parseProxy parses an explicit proxy URL, an empty value uses the environment
+func parseProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL '%s': %w", raw, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL '%s', expected an http, https, socks5 or socks5h URL", proxyURL.Redacted())
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL '%s', missing host", proxyURL.Redacted())
+	}
+
+	return proxyURL, nil
+}
+
+// proxyFromEnvironment reports whether HTTP_PROXY or HTTPS_PROXY is set
+func proxyFromEnvironment() bool {
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		if os.Getenv(key) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSOCKS reports whether the proxy tunnels plain TCP connections
+func isSOCKS(proxyURL *url.URL) bool {
+	return proxyURL != nil && (proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h")
+}
+
+// proxyFunc returns the proxy selection of the HTTP transport
+func (n network) proxyFunc() func(*http.Request) (*url.URL, error) {
+	switch {
+	case n.direct:
+		return nil
+	case n.proxy != nil:
+		return http.ProxyURL(n.proxy)
+	default:
+		return http.ProxyFromEnvironment
+	}
+}
+
+// proxyLabel describes the configured proxy path, without credentials
+func (n network) proxyLabel() string {
+	switch {
+	case n.direct:
+		return "direct"
+	case n.proxy != nil:
+		return n.proxy.Redacted()
+	default:
+		return "environment"
+	}
+}
+
+// proxyFor returns the proxy connections to the server go through, without
+// credentials, or an empty string when they connect directly
+func (n network) proxyFor(server *Server) string {
+	if n.direct {
+		return ""
+	}
+	if n.proxy != nil {
+		return n.proxy.Redacted()
+	}
+	// Environment proxies only apply to HTTP requests
+	if server.URL == "" {
+		return ""
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		return ""
+	}
+	proxyURL, err := http.ProxyFromEnvironment(req)
+	if err != nil || proxyURL == nil {
+		return ""
+	}
+
+	return proxyURL.Redacted()
+}
+
+// dialer returns a dialer for TCP connections from the source address
+func (n network) dialer(timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, Control: n.control}
+	if n.source != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: n.source}
+	}
+
+	return dialer
+}
+
+// dialContext returns the dial function of plain TCP connections, which go
+// through the proxy if it is a SOCKS5 proxy
+func (n network) dialContext(timeout time.Duration) (func(ctx context.Context, network, address string) (net.Conn, error), error) {
+	dialer := n.dialer(timeout)
+	if n.direct || !isSOCKS(n.proxy) {
+		return dialer.DialContext, nil
+	}
+
+	socks, err := proxy.FromURL(n.proxy, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+	}
+
+	return socks.(proxy.ContextDialer).DialContext, nil
+}
+
+// udpDialer returns a dialer for UDP packets from the source address
+func (n network) udpDialer(timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout, Control: n.control}
+	if n.source != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: n.source}
+	}
+
+	return dialer
+}
+
+// systemResolver returns the host's resolver, reading the nameservers from
+// /etc/resolv.conf like the system does, but querying them from the source
+// address and over the IP family of the network
+func (n network) systemResolver(timeout time.Duration) *net.Resolver {
+	udpDialer := n.udpDialer(timeout)
+	tcpDialer := n.dialer(timeout)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if strings.HasPrefix(network, "udp") {
+				return udpDialer.DialContext(ctx, network, address)
+			}
+			return tcpDialer.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// httpClient creates the HTTP client used by the HTTP based backends
+func (n network) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = n.dialer(networkDialTimeout).DialContext
+	transport.Proxy = n.proxyFunc()
+	transport.MaxIdleConnsPerHost = 64
+	// Payloads are random, compression would only cost CPU
+	transport.DisableCompression = true
+
+	return &http.Client{Transport: transport}
+}
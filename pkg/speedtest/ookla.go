@@ -0,0 +1,185 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/showwin/speedtest-go/speedtest"
+	"github.com/showwin/speedtest-go/speedtest/transport"
+)
+
+const (
+	// ooklaDefaultCaptureTime matches the speedtest-go default transfer duration
+	ooklaDefaultCaptureTime = 15 * time.Second
+
+	// ooklaDefaultPacketLossDuration is how long packet loss is sampled without a configured duration
+	ooklaDefaultPacketLossDuration = 10 * time.Second
+)
+
+// ooklaBackend measures against public speedtest.net servers using speedtest-go
+type ooklaBackend struct {
+	client  *speedtest.Speedtest
+	network network
+}
+
+func newOoklaBackend(nw network) *ooklaBackend {
+	config := &speedtest.UserConfig{UserAgent: speedtest.DefaultUserAgent, DialerControl: nw.control}
+	if nw.source != nil {
+		config.Source = nw.source.String()
+	}
+
+	// A client of its own, speedtest-go otherwise installs itself in http.DefaultClient
+	client := speedtest.New(speedtest.WithDoer(&http.Client{}), speedtest.WithUserConfig(config))
+	// speedtest-go always falls back to the environment, a direct path must not
+	config.T.Proxy = nw.proxyFunc()
+
+	return &ooklaBackend{client: client, network: nw}
+}
+
+func (b *ooklaBackend) Name() string {
+	return string(BackendOokla)
+}
+
+func (b *ooklaBackend) remoteServerList() {}
+
+// clientInfo reports the public IP address and ISP speedtest.net sees, it does not tell the ASN
+func (b *ooklaBackend) clientInfo(ctx context.Context, _ *Server) (ClientInfo, error) {
+	user, err := b.client.FetchUserInfoContext(ctx)
+	if err != nil {
+		return ClientInfo{}, err
+	}
+
+	return ClientInfo{IP: user.IP, ISP: user.Isp}, nil
+}
+
+// speedtest-go keeps the transfer settings and rate samples in the client
+func (b *ooklaBackend) serialTransfers() {}
+
+// Servers fetches the speedtest.net server list, ordered by distance
+func (b *ooklaBackend) Servers(ctx context.Context) ([]*Server, error) {
+	serverList, err := b.client.FetchServerListContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*Server, 0, len(serverList))
+	for _, s := range serverList {
+		servers = append(servers, &Server{
+			ID:       s.ID,
+			Name:     s.Name,
+			Country:  s.Country,
+			Host:     s.Host,
+			URL:      s.URL,
+			Distance: s.Distance,
+		})
+	}
+
+	return servers, nil
+}
+
+func (b *ooklaBackend) Ping(ctx context.Context, server *Server, count int) ([]time.Duration, error) {
+	latencies, err := b.native(server).HTTPPing(ctx, count, rankingPingInterval, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]time.Duration, 0, len(latencies))
+	for _, latency := range latencies {
+		samples = append(samples, time.Duration(latency))
+	}
+
+	return samples, nil
+}
+
+func (b *ooklaBackend) Download(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	b.configure(opts)
+	native := b.native(server)
+	start := time.Now()
+	if err := native.DownloadTestContext(ctx); err != nil {
+		return Transfer{}, err
+	}
+	if native.DLSpeed < 0 {
+		return Transfer{}, fmt.Errorf("too many failed download requests")
+	}
+
+	return Transfer{Mbps: native.DLSpeed.Mbps(), Duration: time.Since(start)}, nil
+}
+
+func (b *ooklaBackend) Upload(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	b.configure(opts)
+	native := b.native(server)
+	start := time.Now()
+	if err := native.UploadTestContext(ctx); err != nil {
+		return Transfer{}, err
+	}
+	if native.ULSpeed < 0 {
+		return Transfer{}, fmt.Errorf("too many failed upload requests")
+	}
+
+	return Transfer{Mbps: native.ULSpeed.Mbps(), Duration: time.Since(start)}, nil
+}
+
+// configure applies the transfer options to the client, a stream count of
+// zero lets speedtest-go use one stream per CPU
+func (b *ooklaBackend) configure(opts TransferOptions) {
+	opts = opts.withDefaults(ooklaDefaultCaptureTime, 0)
+	b.client.SetNThread(opts.Streams)
+	b.client.SetCaptureTime(opts.Duration)
+}
+
+// packetLoss sends UDP packets to the server and returns the share it did not receive.
+// Servers that do not offer the packet loss protocol return errPacketLossUnsupported,
+// an explicit proxy errProxyUnsupported.
+func (b *ooklaBackend) packetLoss(ctx context.Context, server *Server, duration time.Duration) (float64, error) {
+	// The UDP probes cannot pass through the proxy and would measure another path
+	if b.network.proxy != nil && !b.network.direct {
+		return 0, fmt.Errorf("packet loss measurement %w", errProxyUnsupported)
+	}
+	if duration <= 0 {
+		duration = ooklaDefaultPacketLossDuration
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	analyzer := speedtest.NewPacketLossAnalyzer(&speedtest.PacketLossAnalyzerOptions{
+		SamplingDuration: duration,
+		TCPDialer:        b.network.dialer(networkDialTimeout),
+		UDPDialer:        b.network.udpDialer(networkDialTimeout),
+	})
+
+	var last *transport.PLoss
+	err := analyzer.RunWithContext(ctx, server.Host, func(loss *transport.PLoss) {
+		last = loss
+	})
+	if errors.Is(err, transport.ErrUnsupported) {
+		return 0, errPacketLossUnsupported
+	}
+	// The sampler only stops on errors, running out of time is the expected end
+	if err != nil && ctx.Err() == nil {
+		return 0, err
+	}
+	if last == nil || last.Sent == 0 {
+		return 0, errPacketLossUnsupported
+	}
+
+	return last.LossPercent(), nil
+}
+
+// native converts the server into its speedtest-go representation
+func (b *ooklaBackend) native(server *Server) *speedtest.Server {
+	return &speedtest.Server{
+		ID:       server.ID,
+		Name:     server.Name,
+		Country:  server.Country,
+		Host:     server.Host,
+		URL:      server.URL,
+		Distance: server.Distance,
+		Latency:  server.Latency,
+		Jitter:   server.Jitter,
+		Context:  b.client,
+	}
+}
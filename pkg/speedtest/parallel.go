@@ -0,0 +1,105 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// runMeasurements runs the planned measurements one after another, or with
+// MeasurementConcurrency > 1 on several servers at the same time. Measurements
+// of the same server always run one after another, in plan order.
+func (r *Runner) runMeasurements(ctx context.Context, plan []plannedMeasurement) ([]*Result, error) {
+	if r.config.MeasurementConcurrency <= 1 {
+		results := make([]*Result, 0, len(plan))
+		for i, planned := range plan {
+			result, err := r.runMeasurement(ctx, i, planned)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+
+		return results, nil
+	}
+
+	if r.config.ParallelThroughput {
+		if _, ok := r.backend.(serialTransfers); ok {
+			fmt.Fprintf(os.Stderr, "Warning: The %s backend cannot run transfers in parallel, serializing them\n", r.backend.Name())
+		}
+	}
+
+	// Group the measurements by server, each group is worked off by one worker
+	var order []string
+	groups := make(map[string][]int)
+	for i, planned := range plan {
+		if _, ok := groups[planned.server.ID]; !ok {
+			order = append(order, planned.server.ID)
+		}
+		groups[planned.server.ID] = append(groups[planned.server.ID], i)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*Result, len(plan))
+	var firstErr error
+	var errOnce sync.Once
+	sem := make(chan struct{}, r.config.MeasurementConcurrency)
+	var wg sync.WaitGroup
+
+	for _, serverID := range order {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			for _, i := range groups[serverID] {
+				if ctx.Err() != nil {
+					return
+				}
+				result, err := r.runMeasurement(ctx, i, plan[i])
+				if err != nil {
+					// The first failure aborts the remaining measurements, as in a sequential run
+					errOnce.Do(func() { firstErr = err })
+					cancel()
+					return
+				}
+				results[i] = result
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// lockPhase keeps concurrent measurements from skewing each other. Transfer
+// phases run exclusively, unless ParallelThroughput allows them to overlap on
+// backends that support it. Light phases run side by side, but never next to
+// a transfer, which would measure the latency under load. The returned
+// function releases the lock.
+func (r *Runner) lockPhase(phaseType PhaseType) func() {
+	_, serial := r.backend.(serialTransfers)
+	if r.config.ParallelThroughput && !serial {
+		return func() {}
+	}
+
+	switch phaseType {
+	case PhaseDownload, PhaseUpload, PhaseLoadedLatency:
+		r.phaseLock.Lock()
+		return r.phaseLock.Unlock
+	default:
+		r.phaseLock.RLock()
+		return r.phaseLock.RUnlock
+	}
+}
@@ -0,0 +1,292 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// pathTraceProbes is the number of probes sent per hop
+	pathTraceProbes = 3
+
+	// pathTraceProbeTimeout is how long a probe waits for its answer
+	pathTraceProbeTimeout = time.Second
+
+	// pathTraceMaxSilentHops ends a trace after this many hops in a row did not answer
+	pathTraceMaxSilentHops = 5
+
+	// pathTraceUDPBasePort is the first destination port of UDP probes, as used by traceroute
+	pathTraceUDPBasePort = 33434
+)
+
+// errPathTraceUnsupported is returned on platforms without unprivileged path tracing
+var errPathTraceUnsupported = errors.New("path tracing is not supported on this platform")
+
+// PathTraceMode selects the probes of the path trace
+type PathTraceMode string
+
+const (
+	// PathTraceOff disables path tracing
+	PathTraceOff PathTraceMode = "off"
+
+	// PathTraceUDP probes with UDP datagrams to unused high ports
+	PathTraceUDP PathTraceMode = "udp"
+
+	// PathTraceTCP probes with TCP connection attempts to the server port,
+	// which passes firewalls dropping UDP
+	PathTraceTCP PathTraceMode = "tcp"
+)
+
+// Valid checks if the path trace mode is valid
+func (m PathTraceMode) Valid() bool {
+	switch m {
+	case PathTraceOff, PathTraceUDP, PathTraceTCP:
+		return true
+	default:
+		return false
+	}
+}
+
+// PathTrace is the route to a test server
+type PathTrace struct {
+	Mode   PathTraceMode
+	Target string
+	// Reason lists the thresholds the result missed
+	Reason  string
+	Hops    []Hop
+	Reached bool
+}
+
+// Hop is a router on the path, or the server itself on the last hop
+type Hop struct {
+	TTL int
+	// Address is empty if no probe was answered
+	Address string
+	// Latency is the median round trip time of the answered probes
+	Latency time.Duration
+	Sent    int
+	Lost    int
+}
+
+// probeResult is the answer to a single probe
+type probeResult struct {
+	address net.IP
+	rtt     time.Duration
+	// reached is set when the answer came from the target
+	reached bool
+}
+
+// degradation returns why the result calls for a path trace, or an empty
+// string if it meets all path trace thresholds. Without any threshold every
+// result is traced.
+func (r *Runner) degradation(result *Result) string {
+	var reasons []string
+	if limit := r.config.PathTraceMinDownload; limit > 0 && result.DownloadMbps > 0 && result.DownloadMbps < limit {
+		reasons = append(reasons, fmt.Sprintf("download %.2f Mbps below %.2f Mbps", result.DownloadMbps, limit))
+	}
+	if limit := r.config.PathTraceMinUpload; limit > 0 && result.UploadMbps > 0 && result.UploadMbps < limit {
+		reasons = append(reasons, fmt.Sprintf("upload %.2f Mbps below %.2f Mbps", result.UploadMbps, limit))
+	}
+	if limit := r.config.PathTraceMaxLatency; limit > 0 && result.Latency > limit {
+		reasons = append(reasons, fmt.Sprintf("latency %v above %v", result.Latency, limit))
+	}
+
+	if len(reasons) == 0 && r.config.PathTraceMinDownload == 0 && r.config.PathTraceMinUpload == 0 && r.config.PathTraceMaxLatency == 0 {
+		return "always"
+	}
+
+	return strings.Join(reasons, ", ")
+}
+
+// runPathTrace traces the path to the server if the result is degraded.
+// A failing trace is recorded on its span but never fails the measurement.
+func (r *Runner) runPathTrace(ctx context.Context, server *Server, result *Result) {
+	if r.config.PathTraceMode == PathTraceOff {
+		return
+	}
+	reason := r.degradation(result)
+	if reason == "" {
+		return
+	}
+
+	ctx, span := tracer.Start(ctx, "speedtest.path_trace")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("server.id", server.ID),
+		attribute.String("path_trace.mode", string(r.config.PathTraceMode)),
+		attribute.String("path_trace.reason", reason),
+	)
+
+	// Behind a proxy the measured traffic never took the path to the server
+	if r.network.proxy != nil && !r.network.direct {
+		span.SetAttributes(attribute.Bool("path_trace.skipped", true))
+		span.SetStatus(codes.Error, "path trace not possible through a proxy")
+		return
+	}
+
+	pathTrace, err := r.tracePath(ctx, server)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "path trace failed")
+		return
+	}
+	pathTrace.Reason = reason
+	result.PathTrace = pathTrace
+
+	for _, hop := range pathTrace.Hops {
+		span.AddEvent("path.hop", trace.WithAttributes(
+			attribute.Int("path.ttl", hop.TTL),
+			attribute.String("path.address", hop.Address),
+			attribute.Int64("path.latency_nanos", hop.Latency.Nanoseconds()),
+			attribute.Int("path.sent", hop.Sent),
+			attribute.Int("path.lost", hop.Lost),
+		))
+	}
+	span.SetAttributes(
+		attribute.String("path_trace.target", pathTrace.Target),
+		attribute.Int("path_trace.hops", len(pathTrace.Hops)),
+		attribute.Bool("path_trace.reached", pathTrace.Reached),
+	)
+	span.SetStatus(codes.Ok, "path trace completed")
+}
+
+// tracePath sends probes with increasing TTL towards the server until it answers
+func (r *Runner) tracePath(ctx context.Context, server *Server) (*PathTrace, error) {
+	host, port, err := traceTarget(server)
+	if err != nil {
+		return nil, err
+	}
+	target, err := r.network.resolveTarget(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	pathTrace := &PathTrace{Mode: r.config.PathTraceMode, Target: target.String()}
+	silent := 0
+	for ttl := 1; ttl <= r.config.PathTraceMaxHops; ttl++ {
+		hop := Hop{TTL: ttl}
+		var rtts []time.Duration
+		for probe := 0; probe < pathTraceProbes; probe++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			hop.Sent++
+			var answer probeResult
+			var err error
+			switch r.config.PathTraceMode {
+			case PathTraceTCP:
+				answer, err = probeTCP(ctx, r.network.source, target, port, ttl)
+			default:
+				answer, err = probeUDP(ctx, r.network.source, target, pathTraceUDPBasePort+ttl-1, ttl)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if answer.address == nil {
+				hop.Lost++
+				continue
+			}
+
+			hop.Address = answer.address.String()
+			rtts = append(rtts, answer.rtt)
+			pathTrace.Reached = pathTrace.Reached || answer.reached
+		}
+		if len(rtts) > 0 {
+			slices.Sort(rtts)
+			hop.Latency = rtts[len(rtts)/2]
+		}
+		pathTrace.Hops = append(pathTrace.Hops, hop)
+
+		if pathTrace.Reached {
+			break
+		}
+		if hop.Address == "" {
+			silent++
+			if silent >= pathTraceMaxSilentHops {
+				break
+			}
+		} else {
+			silent = 0
+		}
+	}
+
+	return pathTrace, nil
+}
+
+// traceTarget returns the host and TCP port of the server, from its host or
+// URL. Without an explicit port, the port follows the scheme of the URL.
+func traceTarget(server *Server) (string, int, error) {
+	var parsed *url.URL
+	if server.URL != "" {
+		if u, err := url.Parse(server.URL); err == nil {
+			parsed = u
+		}
+	}
+
+	defaultPort := 80
+	if parsed != nil {
+		if port := parsed.Port(); port != "" {
+			portNumber, err := strconv.Atoi(port)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid port in server URL %s", server.URL)
+			}
+			defaultPort = portNumber
+		} else if parsed.Scheme == "https" {
+			defaultPort = 443
+		}
+	}
+
+	if server.Host != "" {
+		if host, port, err := net.SplitHostPort(server.Host); err == nil {
+			portNumber, err := strconv.Atoi(port)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid port in server host %s", server.Host)
+			}
+			return host, portNumber, nil
+		}
+		return server.Host, defaultPort, nil
+	}
+
+	if parsed == nil || parsed.Hostname() == "" {
+		return "", 0, fmt.Errorf("server %s has no host to trace", server.ID)
+	}
+
+	return parsed.Hostname(), defaultPort, nil
+}
+
+// resolveTarget resolves the host to an address in the IP family, and in the
+// family of the source address if bound
+func (n network) resolveTarget(ctx context.Context, host string) (net.IP, error) {
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	for _, ip := range ips {
+		if !n.family.matches(ip) {
+			continue
+		}
+		if n.source != nil && (n.source.To4() == nil) != (ip.To4() == nil) {
+			continue
+		}
+		return ip, nil
+	}
+
+	if n.family != IPFamilyAuto {
+		return nil, fmt.Errorf("%s has no %s address to trace", host, n.family)
+	}
+	return nil, fmt.Errorf("%s has no address to trace from %s", host, n.source)
+}
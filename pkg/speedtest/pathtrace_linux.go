@@ -0,0 +1,229 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into the IP_RECVERR control message: struct sock_extended_err is
+// followed by the address of the router that sent the ICMP error
+const (
+	extendedErrOriginOffset   = 4
+	extendedErrTypeOffset     = 5
+	extendedErrCodeOffset     = 6
+	extendedErrOffenderOffset = 16
+)
+
+// ICMP types and codes telling apart routers on the way and the target
+const (
+	icmpTimeExceeded       = 11
+	icmpDestUnreachable    = 3
+	icmpPortUnreachable    = 3
+	icmp6TimeExceeded      = 3
+	icmp6DestUnreachable   = 1
+	icmp6PortUnreachable   = 4
+	pathTraceProbePayload  = "speedster"
+	pathTraceErrQueueBytes = 512
+)
+
+// probeUDP sends a UDP datagram with the TTL and waits for the ICMP error the
+// kernel queues with IP_RECVERR, which works without raw socket privileges
+func probeUDP(ctx context.Context, source, target net.IP, port, ttl int) (probeResult, error) {
+	fd, err := traceSocket(unix.SOCK_DGRAM, source, target, ttl)
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Connect(fd, traceSockaddr(target, port)); err != nil {
+		return probeResult{}, err
+	}
+	start := time.Now()
+	if _, err := unix.Write(fd, []byte(pathTraceProbePayload)); err != nil {
+		// An error queued by an earlier probe surfaces here, the answer is still read below
+		if !errors.Is(err, unix.EHOSTUNREACH) && !errors.Is(err, unix.ECONNREFUSED) {
+			return probeResult{}, err
+		}
+	}
+
+	return awaitProbe(ctx, fd, target, start, unix.POLLIN)
+}
+
+// probeTCP opens a TCP connection with the TTL. Routers on the way answer the
+// SYN with an ICMP error, the target with SYN-ACK or RST.
+func probeTCP(ctx context.Context, source, target net.IP, port, ttl int) (probeResult, error) {
+	fd, err := traceSocket(unix.SOCK_STREAM, source, target, ttl)
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer unix.Close(fd)
+
+	start := time.Now()
+	if err := unix.Connect(fd, traceSockaddr(target, port)); err != nil && !errors.Is(err, unix.EINPROGRESS) {
+		return probeResult{}, err
+	}
+
+	return awaitProbe(ctx, fd, target, start, unix.POLLOUT)
+}
+
+// traceSocket creates a non-blocking socket reporting ICMP errors, with the
+// TTL of the probe and bound to the source address if given
+func traceSocket(sotype int, source, target net.IP, ttl int) (int, error) {
+	domain, level, recvErr, hops := unix.AF_INET, unix.IPPROTO_IP, unix.IP_RECVERR, unix.IP_TTL
+	if target.To4() == nil {
+		domain, level, recvErr, hops = unix.AF_INET6, unix.IPPROTO_IPV6, unix.IPV6_RECVERR, unix.IPV6_UNICAST_HOPS
+	}
+
+	fd, err := unix.Socket(domain, sotype|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.SetsockoptInt(fd, level, recvErr, 1); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	if err := unix.SetsockoptInt(fd, level, hops, ttl); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	if source != nil {
+		if err := unix.Bind(fd, traceSockaddr(source, 0)); err != nil {
+			unix.Close(fd)
+			return -1, err
+		}
+	}
+
+	return fd, nil
+}
+
+// traceSockaddr converts the address into a socket address of its family
+func traceSockaddr(ip net.IP, port int) unix.Sockaddr {
+	if ip4 := ip.To4(); ip4 != nil {
+		addr := &unix.SockaddrInet4{Port: port}
+		copy(addr.Addr[:], ip4)
+		return addr
+	}
+
+	addr := &unix.SockaddrInet6{Port: port}
+	copy(addr.Addr[:], ip.To16())
+	return addr
+}
+
+// awaitProbe waits for the answer to a probe: an ICMP error in the error
+// queue, or the socket becoming ready, which only the target causes. A probe
+// without answer returns an empty result.
+func awaitProbe(ctx context.Context, fd int, target net.IP, start time.Time, ready int16) (probeResult, error) {
+	deadline := start.Add(pathTraceProbeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return probeResult{}, nil
+		}
+
+		fds := []unix.PollFd{{Fd: int32(fd), Events: ready}}
+		n, err := unix.Poll(fds, int(remaining.Milliseconds())+1)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return probeResult{}, err
+		}
+		if n == 0 {
+			continue
+		}
+		rtt := time.Since(start)
+
+		if fds[0].Revents&unix.POLLERR != 0 {
+			if answer, ok := readErrQueue(fd, target); ok {
+				answer.rtt = rtt
+				return answer, nil
+			}
+		}
+
+		// The socket became ready without ICMP error, so the target itself answered
+		soErr, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+		if err != nil {
+			return probeResult{}, err
+		}
+		switch {
+		case soErr == 0, unix.Errno(soErr) == unix.ECONNREFUSED:
+			return probeResult{address: target, rtt: rtt, reached: true}, nil
+		case fds[0].Revents&(unix.POLLERR|unix.POLLHUP) != 0:
+			// An error without ICMP details, e.g. a local routing failure
+			return probeResult{}, unix.Errno(soErr)
+		}
+	}
+}
+
+// readErrQueue reads the ICMP error of the probe from the socket's error queue
+func readErrQueue(fd int, target net.IP) (probeResult, bool) {
+	oob := make([]byte, pathTraceErrQueueBytes)
+	_, oobn, _, _, err := unix.Recvmsg(fd, make([]byte, pathTraceErrQueueBytes), oob, unix.MSG_ERRQUEUE)
+	if err != nil {
+		return probeResult{}, false
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return probeResult{}, false
+	}
+	for _, message := range messages {
+		isV4 := message.Header.Level == unix.IPPROTO_IP && message.Header.Type == unix.IP_RECVERR
+		isV6 := message.Header.Level == unix.IPPROTO_IPV6 && message.Header.Type == unix.IPV6_RECVERR
+		if (!isV4 && !isV6) || len(message.Data) < extendedErrOffenderOffset {
+			continue
+		}
+
+		origin := message.Data[extendedErrOriginOffset]
+		icmpType := message.Data[extendedErrTypeOffset]
+		icmpCode := message.Data[extendedErrCodeOffset]
+		offender := parseOffender(message.Data[extendedErrOffenderOffset:])
+		if offender == nil {
+			continue
+		}
+
+		switch {
+		case origin == unix.SO_EE_ORIGIN_ICMP && icmpType == icmpTimeExceeded,
+			origin == unix.SO_EE_ORIGIN_ICMP6 && icmpType == icmp6TimeExceeded:
+			return probeResult{address: offender}, true
+		case origin == unix.SO_EE_ORIGIN_ICMP && icmpType == icmpDestUnreachable,
+			origin == unix.SO_EE_ORIGIN_ICMP6 && icmpType == icmp6DestUnreachable:
+			// Port unreachable comes from the target, other codes from a router refusing to forward
+			reached := offender.Equal(target) ||
+				(origin == unix.SO_EE_ORIGIN_ICMP && icmpCode == icmpPortUnreachable) ||
+				(origin == unix.SO_EE_ORIGIN_ICMP6 && icmpCode == icmp6PortUnreachable)
+			return probeResult{address: offender, reached: reached}, true
+		}
+	}
+
+	return probeResult{}, false
+}
+
+// parseOffender decodes the sockaddr_in or sockaddr_in6 of the ICMP sender
+func parseOffender(data []byte) net.IP {
+	if len(data) < 2 {
+		return nil
+	}
+
+	switch binary.NativeEndian.Uint16(data) {
+	case unix.AF_INET:
+		if len(data) >= 8 {
+			return net.IP(append([]byte(nil), data[4:8]...))
+		}
+	case unix.AF_INET6:
+		if len(data) >= 24 {
+			return net.IP(append([]byte(nil), data[8:24]...))
+		}
+	}
+
+	return nil
+}
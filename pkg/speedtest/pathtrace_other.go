@@ -0,0 +1,18 @@
+//go:build !linux
+
+package speedtest
+
+import (
+	"context"
+	"net"
+)
+
+// probeUDP needs the Linux error queue to trace without privileges
+func probeUDP(_ context.Context, _, _ net.IP, _, _ int) (probeResult, error) {
+	return probeResult{}, errPathTraceUnsupported
+}
+
+// probeTCP needs the Linux error queue to trace without privileges
+func probeTCP(_ context.Context, _, _ net.IP, _, _ int) (probeResult, error) {
+	return probeResult{}, errPathTraceUnsupported
+}
@@ -0,0 +1,328 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// PhaseType identifies a step of a measurement
+type PhaseType string
+
+const (
+	// PhaseLatency measures the idle round trip time to the server
+	PhaseLatency PhaseType = "latency"
+
+	// PhaseDownload measures the download throughput
+	PhaseDownload PhaseType = "download"
+
+	// PhaseUpload measures the upload throughput
+	PhaseUpload PhaseType = "upload"
+
+	// PhaseLoadedLatency measures the round trip time while a download saturates the connection
+	PhaseLoadedLatency PhaseType = "latency-under-load"
+
+	// PhasePacketLoss measures the share of packets lost on the way to the server
+	PhasePacketLoss PhaseType = "packet-loss"
+)
+
+const (
+	// latencyDefaultDuration is how long the latency phase pings without a configured duration
+	latencyDefaultDuration = time.Second
+
+	// latencyPingInterval is the time budgeted per latency sample
+	latencyPingInterval = 100 * time.Millisecond
+
+	// loadedLatencyDefaultDuration is how long the latency-under-load phase keeps the connection busy
+	loadedLatencyDefaultDuration = 10 * time.Second
+
+	// loadedLatencyInterval is the pause between latency probes while under load
+	loadedLatencyInterval = 400 * time.Millisecond
+)
+
+// DefaultPhases is the phase order used when none is configured
+var DefaultPhases = []PhaseType{PhaseLatency, PhaseDownload, PhaseUpload}
+
+// errPacketLossUnsupported is returned when a server cannot measure packet loss
+var errPacketLossUnsupported = errors.New("packet loss measurement not supported by the server")
+
+// Valid checks if the phase type is valid
+func (p PhaseType) Valid() bool {
+	switch p {
+	case PhaseLatency, PhaseDownload, PhaseUpload, PhaseLoadedLatency, PhasePacketLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// Phase is a step run for every measurement, in the configured order
+type Phase struct {
+	Type     PhaseType
+	Enabled  bool
+	Duration time.Duration
+	Streams  int
+}
+
+// transferOptions returns the transfer options of the phase. Unset options fall
+// back to SPEEDTEST_CONCURRENT_STREAMS and SPEEDTEST_TEST_DURATION, and to the
+// backend default if those are unset as well.
+func (r *Runner) transferOptions(phase Phase) TransferOptions {
+	opts := TransferOptions{Duration: phase.Duration, Streams: phase.Streams}
+	return opts.withDefaults(r.config.TestDuration, r.config.ConcurrentStreams)
+}
+
+// PhaseEnabled reports whether the phase is part of the plan and enabled
+func (c Config) PhaseEnabled(phaseType PhaseType) bool {
+	return slices.ContainsFunc(c.Phases, func(phase Phase) bool {
+		return phase.Type == phaseType && phase.Enabled
+	})
+}
+
+// loadPhases loads the phase plan from environment variables. The order comes
+// from SPEEDTEST_PHASES, each phase is tuned with SPEEDTEST_PHASE_<NAME>_ENABLED,
+// _DURATION and _STREAMS, e.g. SPEEDTEST_PHASE_LATENCY_UNDER_LOAD_DURATION.
+func loadPhases() ([]Phase, error) {
+	names := parseServerIDs(getEnv("SPEEDTEST_PHASES", ""))
+	if len(names) == 0 {
+		for _, phaseType := range DefaultPhases {
+			names = append(names, string(phaseType))
+		}
+	}
+
+	phases := make([]Phase, 0, len(names))
+	for _, name := range names {
+		phaseType := PhaseType(name)
+		if !phaseType.Valid() {
+			return nil, fmt.Errorf("invalid phase '%s'", name)
+		}
+		if slices.ContainsFunc(phases, func(phase Phase) bool { return phase.Type == phaseType }) {
+			return nil, fmt.Errorf("phase '%s' is listed more than once", name)
+		}
+
+		prefix := "SPEEDTEST_PHASE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		phases = append(phases, Phase{
+			Type:     phaseType,
+			Enabled:  getEnvBool(prefix+"_ENABLED", true),
+			Duration: getEnvDuration(prefix+"_DURATION", 0),
+			Streams:  getEnvInt(prefix+"_STREAMS", 0),
+		})
+	}
+
+	// The skip flags predate the phase plan and still disable their phase
+	for phaseType, key := range map[PhaseType]string{PhaseDownload: "SPEEDTEST_SKIP_DOWNLOAD", PhaseUpload: "SPEEDTEST_SKIP_UPLOAD"} {
+		if !getEnvBool(key, false) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s is deprecated, use SPEEDTEST_PHASES or SPEEDTEST_PHASE_%s_ENABLED instead\n", key, strings.ToUpper(string(phaseType)))
+		for i := range phases {
+			if phases[i].Type == phaseType {
+				phases[i].Enabled = false
+			}
+		}
+	}
+
+	return phases, nil
+}
+
+// runPhase runs a single phase of the measurement, storing its outcome in result
+func (r *Runner) runPhase(ctx context.Context, phase Phase, server *Server, result *Result) error {
+	unlock := r.lockPhase(phase.Type)
+	defer unlock()
+
+	switch phase.Type {
+	case PhaseLatency:
+		return r.runLatencyTest(ctx, phase, server, result)
+	case PhaseDownload:
+		return r.runDownloadTest(ctx, phase, server, result)
+	case PhaseUpload:
+		return r.runUploadTest(ctx, phase, server, result)
+	case PhaseLoadedLatency:
+		return r.runLoadedLatencyTest(ctx, phase, server, result)
+	case PhasePacketLoss:
+		return r.runPacketLossTest(ctx, phase, server, result)
+	default:
+		return fmt.Errorf("unknown phase '%s'", phase.Type)
+	}
+}
+
+func (r *Runner) runLatencyTest(ctx context.Context, phase Phase, server *Server, result *Result) error {
+	ctx, span := tracer.Start(ctx, "speedtest.latency_test")
+	defer span.End()
+
+	duration := phase.Duration
+	if duration <= 0 {
+		duration = latencyDefaultDuration
+	}
+	count := max(int(duration/latencyPingInterval), 1)
+
+	span.SetAttributes(
+		attribute.String("server.id", server.ID),
+		attribute.Int("latency.samples", count),
+	)
+
+	samples, err := r.backend.Ping(ctx, server, count)
+	if err != nil {
+		return fmt.Errorf("latency test failed: %w", err)
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("latency test failed: no samples")
+	}
+
+	result.Latency = median(samples)
+	result.Jitter = jitter(samples)
+
+	span.SetAttributes(
+		attribute.Int64("latency.nanos", result.Latency.Nanoseconds()),
+		attribute.Int64("jitter.nanos", result.Jitter.Nanoseconds()),
+	)
+
+	return nil
+}
+
+// runLoadedLatencyTest probes the latency while a download saturates the
+// connection, exposing bufferbloat. The throughput of the load is not reported.
+func (r *Runner) runLoadedLatencyTest(ctx context.Context, phase Phase, server *Server, result *Result) error {
+	ctx, span := tracer.Start(ctx, "speedtest.latency_under_load_test")
+	defer span.End()
+
+	// Backends that transfer a fixed amount would otherwise be done before the first probe
+	opts := r.transferOptions(phase).withDefaults(loadedLatencyDefaultDuration, 0)
+
+	span.SetAttributes(
+		attribute.String("server.id", server.ID),
+		attribute.Int64("load.duration_nanos", opts.Duration.Nanoseconds()),
+		attribute.Int("load.streams", opts.Streams),
+	)
+
+	loadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.backend.Download(loadCtx, server, opts)
+		done <- err
+	}()
+
+	var samples []time.Duration
+	for {
+		select {
+		case err := <-done:
+			// A cancelled run cut the load short, its samples are no valid measurement
+			if ctx.Err() != nil {
+				return fmt.Errorf("latency under load test cancelled: %w", ctx.Err())
+			}
+			if err != nil {
+				return fmt.Errorf("latency under load test failed: %w", err)
+			}
+			if len(samples) == 0 {
+				return fmt.Errorf("latency under load test failed: no samples while the load was running")
+			}
+
+			result.DownloadLoadedLatency = median(samples)
+			span.SetAttributes(
+				attribute.Int("latency.samples", len(samples)),
+				attribute.Int64("latency.loaded_nanos", result.DownloadLoadedLatency.Nanoseconds()),
+			)
+			return nil
+
+		case <-time.After(loadedLatencyInterval):
+			// A failing probe under load is expected now and then, the others still count
+			if latency, err := r.backend.Ping(ctx, server, 1); err == nil && len(latency) > 0 {
+				samples = append(samples, latency[0])
+			}
+		}
+	}
+}
+
+func (r *Runner) runPacketLossTest(ctx context.Context, phase Phase, server *Server, result *Result) error {
+	ctx, span := tracer.Start(ctx, "speedtest.packet_loss_test")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("server.id", server.ID))
+
+	meter, ok := r.backend.(packetLossMeter)
+	if !ok {
+		span.SetAttributes(attribute.Bool("packet_loss.skipped", true))
+		return nil
+	}
+
+	loss, err := meter.packetLoss(ctx, server, phase.Duration)
+	if errors.Is(err, errPacketLossUnsupported) || errors.Is(err, errProxyUnsupported) {
+		fmt.Fprintf(os.Stderr, "Warning: Skipping packet loss for server %s: %v\n", server.ID, err)
+		span.SetAttributes(attribute.Bool("packet_loss.skipped", true))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("packet loss test failed: %w", err)
+	}
+
+	result.PacketLoss = loss
+	result.PacketLossMeasured = true
+	span.SetAttributes(attribute.Float64("packet_loss.percent", loss))
+
+	return nil
+}
+
+// runSingleStreamTest repeats a transfer over a single connection. Comparing it
+// to the multi-stream result exposes throttling of individual connections.
+func (r *Runner) runSingleStreamTest(ctx context.Context, direction PhaseType, opts TransferOptions, server *Server) (Transfer, error) {
+	ctx, span := tracer.Start(ctx, "speedtest.single_stream_test")
+	defer span.End()
+
+	opts.Streams = 1
+	span.SetAttributes(
+		attribute.String("server.id", server.ID),
+		attribute.String("direction", string(direction)),
+	)
+
+	transfer := r.backend.Download
+	if direction == PhaseUpload {
+		transfer = r.backend.Upload
+	}
+
+	result, err := transfer(ctx, server, opts)
+	if err != nil {
+		span.RecordError(err)
+		return Transfer{}, fmt.Errorf("single stream %s test failed: %w", direction, err)
+	}
+
+	span.SetAttributes(
+		attribute.Float64("single_stream.mbps", result.Mbps),
+		attribute.Int64("single_stream.bytes", result.Bytes),
+	)
+
+	return result, nil
+}
+
+// warnUnsupportedPhases reports enabled phases the current backend cannot run
+func (r *Runner) warnUnsupportedPhases() {
+	if _, ok := r.backend.(packetLossMeter); !ok && r.config.PhaseEnabled(PhasePacketLoss) {
+		fmt.Fprintf(os.Stderr, "Warning: The %s backend cannot measure packet loss, skipping the packet-loss phase\n", r.backend.Name())
+	}
+	if r.backend.Name() == string(BackendCloudflare) && r.config.StreamComparison {
+		fmt.Fprintf(os.Stderr, "Warning: The cloudflare backend always transfers over a single stream, the stream comparison shows no difference\n")
+	}
+}
+
+// phaseSpanAttributes returns the measurement span attributes of the phase results
+func (r *Runner) phaseSpanAttributes(result *Result) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if r.config.PhaseEnabled(PhaseDownload) {
+		attrs = append(attrs, attribute.Float64("speedtest.download.mbps", result.DownloadMbps))
+	}
+	if r.config.PhaseEnabled(PhaseUpload) {
+		attrs = append(attrs, attribute.Float64("speedtest.upload.mbps", result.UploadMbps))
+	}
+	if result.PacketLossMeasured {
+		attrs = append(attrs, attribute.Float64("speedtest.packet_loss.percent", result.PacketLoss))
+	}
+
+	return attrs
+}
@@ -0,0 +1,72 @@
+package speedtest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// plannedMeasurement is a single measurement scheduled by the runner
+type plannedMeasurement struct {
+	server     *Server
+	repetition int
+	reused     bool
+}
+
+// planMeasurements turns the selected servers into the ordered list of
+// measurements to run. Every server slot is measured repeatedly; in matrix
+// mode the repetitions are interleaved round by round, otherwise they run
+// back to back. Slots that end up on a server already used by an earlier
+// slot are marked as reused and reported.
+func (r *Runner) planMeasurements(servers []*Server) []plannedMeasurement {
+	slots := servers
+	repetitions := r.config.MeasurementsPerServer
+
+	switch r.config.MeasurementStrategy {
+	case MeasurementStrategySingleServer:
+		// All measurements are repetitions on the same server
+		slots = servers[:1]
+		repetitions = r.config.MeasurementCount
+
+	case MeasurementStrategyMultiServer, MeasurementStrategyMatrix:
+		// Without explicit server IDs, fill up the requested number of servers
+		if len(r.config.ServerIDs) == 0 && len(servers) < r.config.MeasurementCount {
+			slots = make([]*Server, r.config.MeasurementCount)
+			for i := range slots {
+				slots[i] = servers[i%len(servers)]
+			}
+		}
+	}
+
+	// Mark slots using a server that an earlier slot already used
+	reused := make([]bool, len(slots))
+	seen := make(map[string]bool, len(slots))
+	var reusedIDs []string
+	for i, server := range slots {
+		if seen[server.ID] {
+			reused[i] = true
+			reusedIDs = append(reusedIDs, server.ID)
+		}
+		seen[server.ID] = true
+	}
+	if len(reusedIDs) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: Not enough distinct servers available, reusing server(s) %s\n", strings.Join(reusedIDs, ", "))
+	}
+
+	plan := make([]plannedMeasurement, 0, len(slots)*repetitions)
+	if r.config.MeasurementStrategy == MeasurementStrategyMatrix {
+		for repetition := 1; repetition <= repetitions; repetition++ {
+			for i, server := range slots {
+				plan = append(plan, plannedMeasurement{server: server, repetition: repetition, reused: reused[i]})
+			}
+		}
+	} else {
+		for i, server := range slots {
+			for repetition := 1; repetition <= repetitions; repetition++ {
+				plan = append(plan, plannedMeasurement{server: server, repetition: repetition, reused: reused[i]})
+			}
+		}
+	}
+
+	return plan
+}
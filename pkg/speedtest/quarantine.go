@@ -0,0 +1,171 @@
+package speedtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const quarantineStateFile = "quarantine.json"
+
+// QuarantineEntry tracks the recent health of a single server
+type QuarantineEntry struct {
+	Backend             string    `json:"backend,omitempty"`
+	ServerID            string    `json:"server_id"`
+	ServerName          string    `json:"server_name,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastReason          string    `json:"last_reason,omitempty"`
+	LastFailure         time.Time `json:"last_failure,omitempty"`
+	QuarantinedUntil    time.Time `json:"quarantined_until,omitempty"`
+}
+
+// Active reports whether the entry is quarantined at the given time
+func (e QuarantineEntry) Active(now time.Time) bool {
+	return now.Before(e.QuarantinedUntil)
+}
+
+// Quarantine keeps track of misbehaving servers and skips them during
+// automatic server selection once they failed too often in a row.
+// State is persisted in the configured state directory so it survives
+// between runs; without a state directory it only lives for a single run.
+type Quarantine struct {
+	mu        sync.Mutex
+	stateDir  string
+	threshold int
+	cooldown  time.Duration
+	entries   map[string]*QuarantineEntry
+}
+
+// LoadQuarantine loads the quarantine state from stateDir.
+// A threshold of 0 disables quarantining, but failures are still tracked.
+func LoadQuarantine(stateDir string, threshold int, cooldown time.Duration) (*Quarantine, error) {
+	q := &Quarantine{
+		stateDir:  stateDir,
+		threshold: threshold,
+		cooldown:  cooldown,
+		entries:   make(map[string]*QuarantineEntry),
+	}
+
+	if stateDir == "" {
+		return q, nil
+	}
+
+	var entries []*QuarantineEntry
+	if err := ReadState(stateDir, quarantineStateFile, &entries); err != nil {
+		return q, err
+	}
+	for _, entry := range entries {
+		// Entries from before backends existed belong to speedtest.net servers
+		if entry.Backend == "" {
+			entry.Backend = string(BackendOokla)
+		}
+		q.entries[quarantineKey(entry.Backend, entry.ServerID)] = entry
+	}
+
+	return q, nil
+}
+
+// quarantineKey identifies a server across backends, whose server IDs may collide
+func quarantineKey(backend, serverID string) string {
+	return backend + "/" + serverID
+}
+
+// IsQuarantined reports whether the server of the backend is currently quarantined
+func (q *Quarantine) IsQuarantined(backend, serverID string, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.entries[quarantineKey(backend, serverID)]
+	return ok && entry.Active(now)
+}
+
+// RecordFailure registers a failed or anomalous measurement for the server
+// and quarantines it once the threshold of consecutive failures is reached
+func (q *Quarantine) RecordFailure(backend string, server ServerInfo, reason string, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := quarantineKey(backend, server.ID)
+	entry, ok := q.entries[key]
+	if !ok {
+		entry = &QuarantineEntry{Backend: backend, ServerID: server.ID}
+		q.entries[key] = entry
+	}
+
+	entry.ServerName = server.Name
+	entry.ConsecutiveFailures++
+	entry.LastReason = reason
+	entry.LastFailure = now
+
+	if q.threshold > 0 && entry.ConsecutiveFailures >= q.threshold {
+		entry.QuarantinedUntil = now.Add(q.cooldown)
+		entry.ConsecutiveFailures = 0
+	}
+}
+
+// RecordSuccess resets the failure streak of the server of the backend. An
+// active quarantine is not lifted by a single success, it runs until it expires.
+func (q *Quarantine) RecordSuccess(backend, serverID string, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := quarantineKey(backend, serverID)
+	entry, ok := q.entries[key]
+	if !ok {
+		return
+	}
+
+	entry.ConsecutiveFailures = 0
+	if !entry.Active(now) {
+		delete(q.entries, key)
+	}
+}
+
+// Entries returns all currently quarantined servers, sorted by backend and server ID
+func (q *Quarantine) Entries(now time.Time) []QuarantineEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]QuarantineEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		if entry.Active(now) {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Backend != entries[j].Backend {
+			return entries[i].Backend < entries[j].Backend
+		}
+		return entries[i].ServerID < entries[j].ServerID
+	})
+
+	return entries
+}
+
+// Save persists the quarantine state, dropping entries that are neither
+// quarantined nor failing anymore
+func (q *Quarantine) Save(now time.Time) error {
+	if q.stateDir == "" {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]*QuarantineEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		if entry.Active(now) || entry.ConsecutiveFailures > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Backend != entries[j].Backend {
+			return entries[i].Backend < entries[j].Backend
+		}
+		return entries[i].ServerID < entries[j].ServerID
+	})
+
+	return WriteState(q.stateDir, quarantineStateFile, entries)
+}
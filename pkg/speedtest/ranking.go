@@ -0,0 +1,128 @@
+package speedtest
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// rankingPingInterval is the pause between two latency samples of a server
+const rankingPingInterval = 100 * time.Millisecond
+
+// rankServers pings the nearest candidates in parallel and returns the
+// reachable ones ordered by their median latency, lowest first.
+// Candidates with a distance are expected to be ordered by it, as returned
+// by the server list. Without distances, e.g. for the LibreSpeed list, the
+// order says nothing about proximity and every candidate is pinged.
+func (r *Runner) rankServers(ctx context.Context, candidates []*Server) []*Server {
+	span := trace.SpanFromContext(ctx)
+
+	// Always rank enough servers to serve every measurement and the server pool
+	limit := max(r.config.RankingCandidates, r.config.MeasurementCount, r.config.ServerPoolSize)
+	if len(candidates) > limit && hasDistances(candidates) {
+		candidates = candidates[:limit]
+	}
+
+	reachable := r.pingServers(ctx, candidates)
+
+	ranked := make([]*Server, 0, len(candidates))
+	for i, server := range candidates {
+		if !reachable[i] {
+			span.AddEvent("server unreachable", trace.WithAttributes(attribute.String("server.id", server.ID)))
+			continue
+		}
+		ranked = append(ranked, server)
+	}
+
+	slices.SortStableFunc(ranked, func(a, b *Server) int {
+		return cmp.Compare(a.Latency, b.Latency)
+	})
+
+	ranking := make([]string, 0, len(ranked))
+	for _, server := range ranked {
+		ranking = append(ranking, fmt.Sprintf("%s:%s", server.ID, server.Latency.Round(time.Microsecond)))
+	}
+	span.SetAttributes(
+		attribute.Int("ranking.candidates", len(candidates)),
+		attribute.Int("ranking.samples", r.config.RankingSamples),
+		attribute.StringSlice("ranking", ranking),
+	)
+
+	return ranked
+}
+
+// hasDistances reports whether the backend knows the distance of its servers
+func hasDistances(servers []*Server) bool {
+	return slices.ContainsFunc(servers, func(server *Server) bool {
+		return server.Distance > 0
+	})
+}
+
+// pingServers samples the latency of the servers in parallel with bounded
+// concurrency, storing the median latency and jitter in each server.
+// It reports which servers answered at least once.
+func (r *Runner) pingServers(ctx context.Context, servers []*Server) []bool {
+	reachable := make([]bool, len(servers))
+	sem := make(chan struct{}, r.config.RankingConcurrency)
+	var wg sync.WaitGroup
+
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			samples, err := r.backend.Ping(ctx, server, r.config.RankingSamples)
+			if err != nil || len(samples) == 0 {
+				return
+			}
+			server.Latency = median(samples)
+			server.Jitter = jitter(samples)
+			reachable[i] = true
+		}()
+	}
+	wg.Wait()
+
+	return reachable
+}
+
+// median returns the median of the samples, zero without samples
+func median[T time.Duration | float64](samples []T) T {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// jitter returns the mean absolute difference between consecutive latency samples
+func jitter(samples []time.Duration) time.Duration {
+	if len(samples) < 2 {
+		return 0
+	}
+
+	var total time.Duration
+	for i := 1; i < len(samples); i++ {
+		diff := samples[i] - samples[i-1]
+		if diff < 0 {
+			diff = -diff
+		}
+		total += diff
+	}
+
+	return total / time.Duration(len(samples)-1)
+}
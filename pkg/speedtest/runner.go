@@ -2,14 +2,17 @@ package speedtest
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/showwin/speedtest-go/speedtest"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
@@ -26,12 +29,28 @@ const (
 
 	// MeasurementStrategyMultiServer runs each measurement on a different server
 	MeasurementStrategyMultiServer MeasurementStrategy = "multi-server"
+
+	// MeasurementStrategyRoundRobin rotates through the server pool across successive runs
+	MeasurementStrategyRoundRobin MeasurementStrategy = "round-robin"
+
+	// MeasurementStrategyRandom picks servers at random from the pool, weighted by latency
+	MeasurementStrategyRandom MeasurementStrategy = "random"
+
+	// MeasurementStrategyFixedThenRandom runs the first measurement on a fixed server
+	// and the remaining ones on servers picked at random from the pool
+	MeasurementStrategyFixedThenRandom MeasurementStrategy = "fixed-then-random"
+
+	// MeasurementStrategyMatrix measures every server repeatedly, interleaving
+	// the repetitions round by round across all servers
+	MeasurementStrategyMatrix MeasurementStrategy = "matrix"
 )
 
 // Valid checks if the strategy is valid
 func (s MeasurementStrategy) Valid() bool {
 	switch s {
-	case MeasurementStrategySingleServer, MeasurementStrategyMultiServer:
+	case MeasurementStrategySingleServer, MeasurementStrategyMultiServer,
+		MeasurementStrategyRoundRobin, MeasurementStrategyRandom, MeasurementStrategyFixedThenRandom,
+		MeasurementStrategyMatrix:
 		return true
 	default:
 		return false
@@ -40,25 +59,159 @@ func (s MeasurementStrategy) Valid() bool {
 
 // Config holds the speed test configuration
 type Config struct {
-	ServerIDs           []string
-	Timeout             time.Duration
-	ConcurrentStreams   int
-	TestDuration        time.Duration
-	SkipDownload        bool
-	SkipUpload          bool
-	MeasurementCount    int
-	MeasurementStrategy MeasurementStrategy
+	Backends               []BackendType
+	Interfaces             []string
+	IPFamily               IPFamily
+	Proxy                  *url.URL
+	ProxyCompare           bool
+	ClientInfoURL          string
+	RecordClientIP         bool
+	DNSHostnames           []string
+	DNSResolvers           []DNSResolver
+	HTTPTimingURLs         []string
+	HTTPTimingSamples      int
+	PathTraceMode          PathTraceMode
+	PathTraceMinDownload   float64
+	PathTraceMinUpload     float64
+	PathTraceMaxLatency    time.Duration
+	PathTraceMaxHops       int
+	Thresholds             []Threshold
+	Iperf3Servers          []string
+	SpeedsterServers       []string
+	LibreSpeedServers      []string
+	LibreSpeedServerList   string
+	CloudflareBaseURL      string
+	CloudflarePercentile   float64
+	HTTPDownloadURLs       []string
+	HTTPUploadURLs         []string
+	HTTPUploadMethod       string
+	HTTPUploadSize         int64
+	HTTPHeaders            map[string]string
+	ServerIDs              []string
+	Timeout                time.Duration
+	ConcurrentStreams      int
+	TestDuration           time.Duration
+	StreamComparison       bool
+	Phases                 []Phase
+	MeasurementCount       int
+	MeasurementsPerServer  int
+	MeasurementStrategy    MeasurementStrategy
+	ServerPoolSize         int
+	ExcludeServerIDs       []string
+	StateDir               string
+	ServerCacheTTL         time.Duration
+	RankingCandidates      int
+	RankingSamples         int
+	RankingConcurrency     int
+	QuarantineThreshold    int
+	QuarantineCooldown     time.Duration
+	QuarantineMaxMbps      float64
+	MeasurementConcurrency int
+	ParallelThroughput     bool
+}
+
+// String formats the config for logging, without credentials. Header values
+// often carry tokens, so only the header names are shown, and the proxy
+// password is masked.
+func (c Config) String() string {
+	// The alias has no String method, so formatting it does not recurse
+	type config Config
+	redacted := config(c)
+
+	if c.Proxy != nil {
+		proxy := *c.Proxy
+		if _, hasPassword := proxy.User.Password(); hasPassword {
+			proxy.User = url.UserPassword(proxy.User.Username(), "xxxxx")
+		}
+		redacted.Proxy = &proxy
+	}
+
+	if len(c.HTTPHeaders) > 0 {
+		redacted.HTTPHeaders = make(map[string]string, len(c.HTTPHeaders))
+		for name := range c.HTTPHeaders {
+			redacted.HTTPHeaders[name] = "[REDACTED]"
+		}
+	}
+
+	// URLs may carry credentials and tokens in their userinfo and query
+	redacted.ClientInfoURL = redactURL(c.ClientInfoURL)
+	redacted.LibreSpeedServerList = redactURL(c.LibreSpeedServerList)
+	redacted.CloudflareBaseURL = redactURL(c.CloudflareBaseURL)
+	redacted.HTTPTimingURLs = redactURLs(c.HTTPTimingURLs)
+	redacted.SpeedsterServers = redactURLs(c.SpeedsterServers)
+	redacted.LibreSpeedServers = redactURLs(c.LibreSpeedServers)
+	redacted.HTTPDownloadURLs = redactURLs(c.HTTPDownloadURLs)
+	redacted.HTTPUploadURLs = redactURLs(c.HTTPUploadURLs)
+	redacted.DNSResolvers = make([]DNSResolver, len(c.DNSResolvers))
+	for i, resolver := range c.DNSResolvers {
+		if resolver.Protocol == DNSProtocolHTTPS {
+			resolver.Name = redactURL(resolver.Name)
+			resolver.Address = redactURL(resolver.Address)
+		}
+		redacted.DNSResolvers[i] = resolver
+	}
+
+	return fmt.Sprintf("%+v", redacted)
+}
+
+// redactURL strips the userinfo and query of a URL for logging
+func redactURL(target string) string {
+	if target == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "invalid URL"
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+
+	return parsed.String()
+}
+
+// redactURLs strips the userinfo and query of every URL for logging
+func redactURLs(targets []string) []string {
+	if targets == nil {
+		return nil
+	}
+
+	redacted := make([]string, len(targets))
+	for i, target := range targets {
+		redacted[i] = redactURL(target)
+	}
+
+	return redacted
 }
 
 // Result holds the speed test results
 type Result struct {
-	Server           ServerInfo
-	DownloadMbps     float64
-	UploadMbps       float64
-	Duration         time.Duration
-	Latency          time.Duration
-	Jitter           time.Duration
-	MeasurementIndex int
+	Backend                  string
+	Server                   ServerInfo
+	DownloadMbps             float64
+	UploadMbps               float64
+	DownloadTTFB             time.Duration
+	DownloadDuration         time.Duration
+	UploadDuration           time.Duration
+	DownloadLoadedLatency    time.Duration
+	UploadLoadedLatency      time.Duration
+	DownloadSingleStreamMbps float64
+	UploadSingleStreamMbps   float64
+	PacketLoss               float64
+	PacketLossMeasured       bool
+	Duration                 time.Duration
+	Latency                  time.Duration
+	Jitter                   time.Duration
+	Interface                string
+	IPFamily                 string
+	Proxy                    string
+	Client                   ClientInfo
+	ISPChanged               bool
+	PathTrace                *PathTrace
+	Evaluation               Evaluation
+	MeasurementIndex         int
+	Repetition               int
+	ServerReused             bool
 }
 
 // ServerInfo contains information about the test server
@@ -69,9 +222,52 @@ type ServerInfo struct {
 	Distance float64
 }
 
+// backendRun is a backend bound to the network it measures through
+type backendRun struct {
+	backend Backend
+	network network
+
+	// path is proxyPathDirect or proxyPathProxy when comparing both, empty otherwise
+	path string
+}
+
+// label identifies the run in warnings and errors
+func (b backendRun) label() string {
+	label := b.backend.Name()
+	if b.network.iface != "" {
+		label += "@" + b.network.iface
+	}
+	if family := b.network.family.label(); family != "" {
+		label += "/" + family
+	}
+	if b.path != "" {
+		label += "/" + b.path
+	}
+
+	return label
+}
+
+// selectionKey identifies the runs that measure the same servers, which
+// differ only in the IP family or proxy path
+func (b backendRun) selectionKey() string {
+	return b.backend.Name() + "@" + b.network.iface
+}
+
 // Runner executes speed tests
 type Runner struct {
-	config Config
+	config        Config
+	runs          []backendRun
+	backend       Backend
+	network       network
+	selection     string
+	client        ClientInfo
+	clientChanged bool
+	dnsResults    []DNSResult
+	httpTimings   []HTTPTiming
+	selections    map[string][]*Server
+	quarantine    *Quarantine
+	serverCaches  map[string]*serverListCache
+	phaseLock     sync.RWMutex
 }
 
 // LoadConfig loads configuration from environment variables
@@ -87,6 +283,123 @@ func LoadConfig() Config {
 		strategy = MeasurementStrategySingleServer
 	}
 
+	// Parse backends from comma-separated list, several backends run the same measurement plan each
+	var backends []BackendType
+	for _, name := range parseServerIDs(getEnv("SPEEDTEST_BACKEND", string(BackendOokla))) {
+		backend := BackendType(name)
+		if !backend.Valid() {
+			fmt.Fprintf(os.Stderr, "Error: Invalid backend '%s'\n", backend)
+			os.Exit(1)
+		}
+		if !slices.Contains(backends, backend) {
+			backends = append(backends, backend)
+		}
+	}
+	if len(backends) == 0 {
+		backends = []BackendType{BackendOokla}
+	}
+
+	// Parse the ordered phase plan with its per-phase settings
+	phases, err := loadPhases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	downloadEnabled := slices.ContainsFunc(phases, func(phase Phase) bool {
+		return phase.Enabled && (phase.Type == PhaseDownload || phase.Type == PhaseLoadedLatency)
+	})
+	uploadEnabled := slices.ContainsFunc(phases, func(phase Phase) bool {
+		return phase.Enabled && phase.Type == PhaseUpload
+	})
+
+	// IP version to measure over, "both" measures every server over IPv4 and IPv6
+	ipFamily := IPFamily(strings.ToLower(getEnv("SPEEDTEST_IP_FAMILY", string(IPFamilyAuto))))
+	if !ipFamily.Valid() {
+		fmt.Fprintf(os.Stderr, "Error: Invalid IP family '%s', expected auto, ipv4, ipv6 or both\n", ipFamily)
+		os.Exit(1)
+	}
+
+	// Explicit proxy, without one HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply
+	proxyURL, err := parseProxy(getEnv("SPEEDTEST_PROXY", ""))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if proxyURL != nil && !isSOCKS(proxyURL) && slices.Contains(backends, BackendIperf3) {
+		fmt.Fprintf(os.Stderr, "Error: The iperf3 backend only supports SOCKS5 proxies\n")
+		os.Exit(1)
+	}
+	proxyCompare := getEnvBool("SPEEDTEST_PROXY_COMPARE", false)
+	if proxyCompare && proxyURL == nil && !proxyFromEnvironment() {
+		fmt.Fprintf(os.Stderr, "Warning: SPEEDTEST_PROXY_COMPARE requires a proxy, measuring the direct path only\n")
+		proxyCompare = false
+	}
+
+	// Explicit resolvers the DNS phase measures next to the system resolver
+	dnsResolvers, err := parseDNSResolvers(parseServerIDs(getEnv("SPEEDTEST_DNS_RESOLVERS", "")))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// URLs the HTTP timing phase requests
+	httpTimingURLs := parseServerIDs(getEnv("SPEEDTEST_HTTP_TIMING_URLS", ""))
+	for _, target := range httpTimingURLs {
+		if parsed, err := url.Parse(target); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			fmt.Fprintf(os.Stderr, "Error: Invalid HTTP timing URL '%s', expected an http or https URL\n", target)
+			os.Exit(1)
+		}
+	}
+
+	// Probes of the path trace run for results below the path trace thresholds
+	pathTraceMode := PathTraceMode(strings.ToLower(getEnv("SPEEDTEST_PATH_TRACE", string(PathTraceOff))))
+	if !pathTraceMode.Valid() {
+		fmt.Fprintf(os.Stderr, "Error: Invalid path trace mode '%s', expected off, udp or tcp\n", pathTraceMode)
+		os.Exit(1)
+	}
+
+	// Thresholds the results are evaluated against after the run
+	thresholds, err := loadThresholds()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Parse iperf3 servers from comma-separated list of host[:port]
+	iperf3Servers := parseServerIDs(getEnv("SPEEDTEST_IPERF3_SERVERS", ""))
+	if slices.Contains(backends, BackendIperf3) && len(iperf3Servers) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: The iperf3 backend requires SPEEDTEST_IPERF3_SERVERS\n")
+		os.Exit(1)
+	}
+
+	// Parse speedster test server base URLs from comma-separated list
+	speedsterServers := parseServerIDs(getEnv("SPEEDTEST_SPEEDSTER_SERVERS", ""))
+	if slices.Contains(backends, BackendSpeedster) && len(speedsterServers) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: The speedster backend requires SPEEDTEST_SPEEDSTER_SERVERS\n")
+		os.Exit(1)
+	}
+
+	// Parse private LibreSpeed instance base URLs, the server list is used without them
+	libreSpeedServers := parseServerIDs(getEnv("SPEEDTEST_LIBRESPEED_SERVERS", ""))
+
+	// Percentile of the per-request throughput reported by the cloudflare backend
+	cloudflarePercentile := getEnvFloat("SPEEDTEST_CLOUDFLARE_PERCENTILE", 0.9)
+	if cloudflarePercentile <= 0 || cloudflarePercentile > 1 {
+		fmt.Fprintf(os.Stderr, "Warning: Invalid Cloudflare percentile %v, defaulting to 0.9\n", cloudflarePercentile)
+		cloudflarePercentile = 0.9
+	}
+
+	// Parse HTTP backend targets from comma-separated lists of URLs
+	httpDownloadURLs := parseServerIDs(getEnv("SPEEDTEST_HTTP_DOWNLOAD_URLS", ""))
+	httpUploadURLs := parseServerIDs(getEnv("SPEEDTEST_HTTP_UPLOAD_URLS", ""))
+	httpUploadMethod := strings.ToUpper(getEnv("SPEEDTEST_HTTP_UPLOAD_METHOD", http.MethodPost))
+	if slices.Contains(backends, BackendHTTP) {
+		if err := validateHTTPTargets(httpDownloadURLs, httpUploadURLs, httpUploadMethod, downloadEnabled, uploadEnabled); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Parse server IDs from comma-separated list
 	serverIDs := parseServerIDs(getEnv("SPEEDTEST_SERVER_ID", ""))
 
@@ -96,15 +409,63 @@ func LoadConfig() Config {
 		os.Exit(1)
 	}
 
+	// Parse and validate excluded server IDs
+	excludeServerIDs := parseServerIDs(getEnv("SPEEDTEST_EXCLUDE_SERVER_IDS", ""))
+	if err := validateExcludedServerIDs(serverIDs, excludeServerIDs); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	return Config{
-		ServerIDs:           serverIDs,
-		Timeout:             getEnvDuration("SPEEDTEST_TIMEOUT", 30*time.Second),
-		ConcurrentStreams:   getEnvInt("SPEEDTEST_CONCURRENT_STREAMS", 0),
-		TestDuration:        getEnvDuration("SPEEDTEST_TEST_DURATION", 0),
-		SkipDownload:        getEnvBool("SPEEDTEST_SKIP_DOWNLOAD", false),
-		SkipUpload:          getEnvBool("SPEEDTEST_SKIP_UPLOAD", false),
-		MeasurementCount:    measurementCount,
-		MeasurementStrategy: strategy,
+		Backends:               backends,
+		Interfaces:             parseServerIDs(getEnv("SPEEDTEST_INTERFACE", "")),
+		IPFamily:               ipFamily,
+		Proxy:                  proxyURL,
+		ProxyCompare:           proxyCompare,
+		ClientInfoURL:          getEnv("SPEEDTEST_CLIENT_INFO_URL", ""),
+		RecordClientIP:         getEnvBool("SPEEDTEST_RECORD_CLIENT_IP", false),
+		DNSHostnames:           parseServerIDs(getEnv("SPEEDTEST_DNS_HOSTNAMES", "")),
+		DNSResolvers:           dnsResolvers,
+		HTTPTimingURLs:         httpTimingURLs,
+		HTTPTimingSamples:      max(getEnvInt("SPEEDTEST_HTTP_TIMING_SAMPLES", 3), 1),
+		PathTraceMode:          pathTraceMode,
+		PathTraceMinDownload:   getEnvFloat("SPEEDTEST_PATH_TRACE_MIN_DOWNLOAD_MBPS", 0),
+		PathTraceMinUpload:     getEnvFloat("SPEEDTEST_PATH_TRACE_MIN_UPLOAD_MBPS", 0),
+		PathTraceMaxLatency:    getEnvDuration("SPEEDTEST_PATH_TRACE_MAX_LATENCY", 0),
+		PathTraceMaxHops:       max(getEnvInt("SPEEDTEST_PATH_TRACE_MAX_HOPS", 30), 1),
+		Thresholds:             thresholds,
+		Iperf3Servers:          iperf3Servers,
+		SpeedsterServers:       speedsterServers,
+		LibreSpeedServers:      libreSpeedServers,
+		LibreSpeedServerList:   getEnv("SPEEDTEST_LIBRESPEED_SERVER_LIST_URL", LibreSpeedDefaultServerList),
+		CloudflareBaseURL:      getEnv("SPEEDTEST_CLOUDFLARE_BASE_URL", CloudflareDefaultBaseURL),
+		CloudflarePercentile:   cloudflarePercentile,
+		HTTPDownloadURLs:       httpDownloadURLs,
+		HTTPUploadURLs:         httpUploadURLs,
+		HTTPUploadMethod:       httpUploadMethod,
+		HTTPUploadSize:         int64(max(getEnvInt("SPEEDTEST_HTTP_UPLOAD_SIZE", httpChunkSize), 1)),
+		HTTPHeaders:            parseHeaders(getEnv("SPEEDTEST_HTTP_HEADERS", "")),
+		ServerIDs:              serverIDs,
+		Timeout:                getEnvDuration("SPEEDTEST_TIMEOUT", 30*time.Second),
+		ConcurrentStreams:      getEnvInt("SPEEDTEST_CONCURRENT_STREAMS", 0),
+		TestDuration:           getEnvDuration("SPEEDTEST_TEST_DURATION", 0),
+		StreamComparison:       getEnvBool("SPEEDTEST_STREAM_COMPARISON", false),
+		Phases:                 phases,
+		MeasurementCount:       measurementCount,
+		MeasurementsPerServer:  max(getEnvInt("SPEEDTEST_MEASUREMENTS_PER_SERVER", 1), 1),
+		MeasurementStrategy:    strategy,
+		ServerPoolSize:         max(getEnvInt("SPEEDTEST_SERVER_POOL_SIZE", 5), 1),
+		ExcludeServerIDs:       excludeServerIDs,
+		StateDir:               getEnv("SPEEDTEST_STATE_DIR", ""),
+		ServerCacheTTL:         getEnvDuration("SPEEDTEST_SERVER_CACHE_TTL", time.Hour),
+		RankingCandidates:      max(getEnvInt("SPEEDTEST_RANKING_CANDIDATES", 10), 1),
+		RankingSamples:         max(getEnvInt("SPEEDTEST_RANKING_SAMPLES", 3), 1),
+		RankingConcurrency:     max(getEnvInt("SPEEDTEST_RANKING_CONCURRENCY", 4), 1),
+		QuarantineThreshold:    getEnvInt("SPEEDTEST_QUARANTINE_THRESHOLD", 0),
+		QuarantineCooldown:     getEnvDuration("SPEEDTEST_QUARANTINE_COOLDOWN", 24*time.Hour),
+		QuarantineMaxMbps:      getEnvFloat("SPEEDTEST_QUARANTINE_MAX_MBPS", 0),
+		MeasurementConcurrency: max(getEnvInt("SPEEDTEST_MEASUREMENT_CONCURRENCY", 1), 1),
+		ParallelThroughput:     getEnvBool("SPEEDTEST_PARALLEL_THROUGHPUT", false),
 	}
 }
 
@@ -127,6 +488,48 @@ func parseServerIDs(serverIDStr string) []string {
 	return serverIDs
 }
 
+// parseHeaders parses key=value HTTP headers, one per line
+func parseHeaders(headerStr string) map[string]string {
+	headers := make(map[string]string)
+	// Header values may contain commas, e.g. Accept or Cookie, but never newlines
+	for _, part := range strings.Split(headerStr, "\n") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			fmt.Fprintf(os.Stderr, "Warning: Ignoring malformed HTTP header '%s', expected key=value\n", part)
+			continue
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+
+	return headers
+}
+
+// validateHTTPTargets validates that the HTTP backend has a URL for every enabled test
+func validateHTTPTargets(downloadURLs, uploadURLs []string, uploadMethod string, downloadEnabled, uploadEnabled bool) error {
+	if len(downloadURLs) == 0 && len(uploadURLs) == 0 {
+		return fmt.Errorf("the http backend requires SPEEDTEST_HTTP_DOWNLOAD_URLS and/or SPEEDTEST_HTTP_UPLOAD_URLS")
+	}
+	if len(downloadURLs) == 0 && downloadEnabled {
+		return fmt.Errorf("the http backend has no download URLs, set SPEEDTEST_HTTP_DOWNLOAD_URLS or disable the download phases")
+	}
+	if len(uploadURLs) == 0 && uploadEnabled {
+		return fmt.Errorf("the http backend has no upload URLs, set SPEEDTEST_HTTP_UPLOAD_URLS or disable the upload phase")
+	}
+	if len(downloadURLs) > 1 && len(uploadURLs) > 1 && len(downloadURLs) != len(uploadURLs) {
+		return fmt.Errorf("%d download and %d upload URLs cannot be paired, give the same number or a single upload URL", len(downloadURLs), len(uploadURLs))
+	}
+	if uploadMethod != http.MethodPost && uploadMethod != http.MethodPut {
+		return fmt.Errorf("invalid upload method '%s', expected POST or PUT", uploadMethod)
+	}
+
+	return nil
+}
+
 // validateServerIDs validates that the number of server IDs matches the strategy requirements
 func validateServerIDs(serverIDs []string, strategy MeasurementStrategy, measurementCount int) error {
 	idCount := len(serverIDs)
@@ -138,11 +541,25 @@ func validateServerIDs(serverIDs []string, strategy MeasurementStrategy, measure
 			return fmt.Errorf("in single-server mode, you can only specify 0 or 1 server ID (found %d)", idCount)
 		}
 
-	case MeasurementStrategyMultiServer:
-		// Multi-server mode: 0 or exactly measurementCount server IDs allowed
+	case MeasurementStrategyMultiServer, MeasurementStrategyMatrix:
+		// Multi-server and matrix mode: any number of server IDs, each one is
+		// measured MeasurementsPerServer times instead of measurementCount servers
 		if idCount != 0 && idCount != measurementCount {
-			return fmt.Errorf("in multi-server mode with %d measurements, you must provide exactly %d server IDs or none (found %d)",
-				measurementCount, measurementCount, idCount)
+			fmt.Fprintf(os.Stderr, "Warning: %d server IDs given, measuring each of them instead of %d servers\n", idCount, measurementCount)
+		}
+
+	case MeasurementStrategyRoundRobin, MeasurementStrategyRandom, MeasurementStrategyFixedThenRandom:
+		// Pool-based modes: any number of server IDs forms the pool
+	}
+
+	return nil
+}
+
+// validateExcludedServerIDs validates that no explicitly requested server is excluded
+func validateExcludedServerIDs(serverIDs, excludeServerIDs []string) error {
+	for _, id := range serverIDs {
+		if slices.Contains(excludeServerIDs, id) {
+			return fmt.Errorf("server ID %s is both requested and excluded", id)
 		}
 	}
 
@@ -150,103 +567,180 @@ func validateServerIDs(serverIDs []string, strategy MeasurementStrategy, measure
 }
 
 // NewRunner creates a new speed test runner
-func NewRunner(config Config) *Runner {
-	return &Runner{config: config}
+func NewRunner(config Config) (*Runner, error) {
+	// Every backend measures through every interface, or the default route without
+	// any, over each IP family back to back
+	interfaces := config.Interfaces
+	if len(interfaces) == 0 {
+		interfaces = []string{""}
+	}
+	ipFamily := config.IPFamily
+	if ipFamily == "" {
+		ipFamily = IPFamilyAuto
+	}
+
+	// Proxy comparison measures the direct path first as baseline, then the proxied one
+	paths := []string{""}
+	if config.ProxyCompare {
+		paths = []string{proxyPathDirect, proxyPathProxy}
+	}
+
+	var runs []backendRun
+	for _, iface := range interfaces {
+		for _, backendType := range config.Backends {
+			for _, family := range ipFamily.families() {
+				nw, err := newNetwork(iface, family)
+				if err != nil {
+					return nil, err
+				}
+				nw.proxy = config.Proxy
+				for _, path := range paths {
+					nw.direct = path == proxyPathDirect
+					backend, err := newBackend(config, backendType, nw)
+					if err != nil {
+						return nil, err
+					}
+					runs = append(runs, backendRun{backend: backend, network: nw, path: path})
+				}
+			}
+		}
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no backend configured")
+	}
+
+	quarantine, err := LoadQuarantine(config.StateDir, config.QuarantineThreshold, config.QuarantineCooldown)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load quarantine state, starting fresh: %v\n", err)
+	}
+
+	return &Runner{
+		config:       config,
+		runs:         runs,
+		backend:      runs[0].backend,
+		network:      runs[0].network,
+		quarantine:   quarantine,
+		serverCaches: make(map[string]*serverListCache),
+		selections:   make(map[string][]*Server),
+	}, nil
+}
+
+// Quarantine returns the server quarantine used by the runner
+func (r *Runner) Quarantine() *Quarantine {
+	return r.quarantine
+}
+
+// DNSResults returns the resolutions of the last run's DNS phase
+func (r *Runner) DNSResults() []DNSResult {
+	return r.dnsResults
+}
+
+// HTTPTimings returns the requests of the last run's HTTP timing phase
+func (r *Runner) HTTPTimings() []HTTPTiming {
+	return r.httpTimings
 }
 
-// Run executes the speed test with tracing and returns all measurement results
+// distinctNetworks returns the networks the runs measure through, in run
+// order. Without withProxy, runs that only differ in the proxy path share
+// the network of the first of them.
+func (r *Runner) distinctNetworks(withProxy bool) []network {
+	var networks []network
+	seen := make(map[string]bool)
+	for _, run := range r.runs {
+		key := run.network.iface + "/" + string(run.network.family)
+		if withProxy {
+			key = run.network.key()
+		}
+		if !seen[key] {
+			seen[key] = true
+			networks = append(networks, run.network)
+		}
+	}
+
+	return networks
+}
+
+// Run executes the speed test with tracing and returns all measurement results.
+// With several backends or interfaces the same measurement plan runs on each
+// backend through each interface, one after another; a failing backend is
+// skipped as long as another backend produces results.
 func (r *Runner) Run(ctx context.Context) ([]*Result, error) {
 	ctx, span := tracer.Start(ctx, "speedtest.execution")
 	defer span.End()
 
-	// Select servers based on strategy
-	servers, err := r.selectServers(ctx)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "server selection failed")
-		return nil, fmt.Errorf("server selection failed: %w", err)
-	}
+	defer func() {
+		if err := r.quarantine.Save(time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save quarantine state: %v\n", err)
+		}
+	}()
 
+	names := make([]string, 0, len(r.config.Backends))
+	for _, backendType := range r.config.Backends {
+		names = append(names, string(backendType))
+	}
 	span.SetAttributes(
+		attribute.StringSlice("backends", names),
+		attribute.StringSlice("interfaces", r.config.Interfaces),
+		attribute.String("ip_family", string(r.config.IPFamily)),
+		attribute.Bool("proxy_compare", r.config.ProxyCompare),
+		attribute.String("proxy", network{proxy: r.config.Proxy}.proxyLabel()),
 		attribute.Int("measurement_count", r.config.MeasurementCount),
 		attribute.String("measurement_strategy", string(r.config.MeasurementStrategy)),
+		attribute.Int("concurrent_streams", r.config.ConcurrentStreams),
+		attribute.Int64("test_duration_nanos", r.config.TestDuration.Nanoseconds()),
+		attribute.Bool("stream_comparison", r.config.StreamComparison),
+		attribute.StringSlice("dns_hostnames", r.config.DNSHostnames),
+		attribute.StringSlice("http_timing_urls", redactURLs(r.config.HTTPTimingURLs)),
 	)
 
-	results := make([]*Result, 0, r.config.MeasurementCount)
+	// DNS and HTTP timing are measured once per network, independent of the backends
+	r.dnsResults = r.runDNSTest(ctx)
+	r.httpTimings = r.runHTTPTimingTest(ctx)
 
-	// Run measurements
-	for i := 0; i < r.config.MeasurementCount; i++ {
-		measurementCtx, measurementSpan := tracer.Start(ctx, fmt.Sprintf("speedtest.measurement_%d", i+1))
-
-		// Select server for this measurement
-		var server *speedtest.Server
-		if r.config.MeasurementStrategy == MeasurementStrategySingleServer {
-			// Reuse the same server for all measurements
-			server = servers[0]
-		} else {
-			// Use a different server for each measurement
-			if i < len(servers) {
-				server = servers[i]
-			} else {
-				// Fallback if we run out of servers
-				server = servers[i%len(servers)]
-			}
-		}
+	var results []*Result
+	var errs []error
+	var client ClientInfo
+	var ispChanged bool
+	for _, run := range r.runs {
+		r.backend = run.backend
+		r.network = run.network
+		r.selection = run.selectionKey()
 
-		measurementSpan.SetAttributes(
-			attribute.Int("measurement_index", i+1),
-			attribute.String("speedtest.server.id", server.ID),
-			attribute.String("speedtest.server.name", server.Name),
-			attribute.String("speedtest.server.country", server.Country),
-			attribute.Float64("speedtest.server.distance", server.Distance),
-		)
-
-		startTime := time.Now()
-
-		result := &Result{
-			Server: ServerInfo{
-				ID:       server.ID,
-				Name:     server.Name,
-				Country:  server.Country,
-				Distance: server.Distance,
-			},
-			MeasurementIndex: i + 1,
-		}
-
-		// Run download test
-		if !r.config.SkipDownload {
-			downloadMbps, err := r.runDownloadTest(measurementCtx, server)
-			if err != nil {
-				measurementSpan.RecordError(err)
-				measurementSpan.SetStatus(codes.Error, "download test failed")
-				measurementSpan.End()
-				return nil, fmt.Errorf("download test failed for measurement %d: %w", i+1, err)
-			}
-			result.DownloadMbps = downloadMbps
-			measurementSpan.SetAttributes(attribute.Float64("speedtest.download.mbps", downloadMbps))
-		}
-
-		// Run upload test
-		if !r.config.SkipUpload {
-			uploadMbps, err := r.runUploadTest(measurementCtx, server)
-			if err != nil {
-				measurementSpan.RecordError(err)
-				measurementSpan.SetStatus(codes.Error, "upload test failed")
-				measurementSpan.End()
-				return nil, fmt.Errorf("upload test failed for measurement %d: %w", i+1, err)
+		backendResults, err := r.runBackend(ctx, run.label())
+		if err != nil {
+			if len(r.runs) == 1 {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "speed test failed")
+				return nil, err
 			}
-			result.UploadMbps = uploadMbps
-			measurementSpan.SetAttributes(attribute.Float64("speedtest.upload.mbps", uploadMbps))
+
+			fmt.Fprintf(os.Stderr, "Warning: Backend %s failed, continuing with the remaining backends: %v\n", run.label(), err)
+			span.RecordError(err)
+			errs = append(errs, fmt.Errorf("%s: %w", run.label(), err))
+			continue
 		}
+		results = append(results, backendResults...)
 
-		result.Duration = time.Since(startTime)
-		result.Latency = server.Latency
-		result.Jitter = server.Jitter
+		// The root span carries the first connection detected, the backend spans all of them
+		if !client.Known() {
+			client = r.client
+		}
+		ispChanged = ispChanged || r.clientChanged
+	}
 
-		measurementSpan.SetStatus(codes.Ok, "measurement completed successfully")
-		measurementSpan.End()
+	span.SetAttributes(
+		attribute.String("client.isp", client.ISP),
+		attribute.Int("client.asn", client.ASN),
+		attribute.Bool("client.isp_changed", ispChanged),
+	)
+	if client.IP != "" {
+		span.SetAttributes(attribute.String("client.ip", client.IP))
+	}
 
-		results = append(results, result)
+	if len(results) == 0 {
+		err := errors.Join(errs...)
+		span.SetStatus(codes.Error, "all backends failed")
+		return nil, fmt.Errorf("all backends failed: %w", err)
 	}
 
 	span.SetStatus(codes.Ok, "speed test completed successfully")
@@ -254,45 +748,219 @@ func (r *Runner) Run(ctx context.Context) ([]*Result, error) {
 	return results, nil
 }
 
-func (r *Runner) selectServers(ctx context.Context) ([]*speedtest.Server, error) {
-	ctx, span := tracer.Start(ctx, "speedtest.server_selection")
+// runBackend runs the measurement plan on the current backend, label identifies the run
+func (r *Runner) runBackend(ctx context.Context, label string) ([]*Result, error) {
+	ctx, span := tracer.Start(ctx, "speedtest.backend")
 	defer span.End()
 
-	user := speedtest.New()
+	span.SetAttributes(
+		attribute.String("backend", r.backend.Name()),
+		attribute.String("interface", r.network.iface),
+		attribute.String("ip_family", r.network.family.label()),
+		attribute.String("proxy", r.network.proxyLabel()),
+	)
+	if r.network.source != nil {
+		span.SetAttributes(attribute.String("source_address", r.network.source.String()))
+	}
+
+	// Select servers based on strategy, each IP family measures the servers selected for the first one
+	servers, ok := r.selections[r.selection]
+	if !ok {
+		var err error
+		servers, err = r.selectServers(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "server selection failed")
+			return nil, fmt.Errorf("server selection failed: %w", err)
+		}
+		r.selections[r.selection] = servers
+	}
+
+	// Record who the servers see as the client, the connection may differ per run
+	r.client, r.clientChanged = r.detectClient(ctx, label, servers[0])
+
+	r.warnUnsupportedPhases()
+
+	plan := r.planMeasurements(servers)
+
+	span.SetAttributes(
+		attribute.Int("planned_measurements", len(plan)),
+		attribute.Int("measurement_concurrency", r.config.MeasurementConcurrency),
+	)
+
+	// Run measurements
+	results, err := r.runMeasurements(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetStatus(codes.Ok, "backend completed successfully")
+
+	return results, nil
+}
+
+// runMeasurement runs the enabled phases of a single planned measurement
+func (r *Runner) runMeasurement(ctx context.Context, i int, planned plannedMeasurement) (*Result, error) {
+	measurementCtx, measurementSpan := tracer.Start(ctx, fmt.Sprintf("speedtest.measurement_%d", i+1))
+	defer measurementSpan.End()
+
+	server := planned.server
+
+	measurementSpan.SetAttributes(
+		attribute.String("backend", r.backend.Name()),
+		attribute.String("interface", r.network.iface),
+		attribute.String("ip_family", r.network.family.label()),
+		attribute.String("proxy", r.network.proxyFor(server)),
+		attribute.Int("measurement_index", i+1),
+		attribute.Int("repetition", planned.repetition),
+		attribute.Bool("speedtest.server.reused", planned.reused),
+		attribute.String("speedtest.server.id", server.ID),
+		attribute.String("speedtest.server.name", server.Name),
+		attribute.String("speedtest.server.country", server.Country),
+		attribute.Float64("speedtest.server.distance", server.Distance),
+	)
+
+	startTime := time.Now()
+
+	result := &Result{
+		Backend:          r.backend.Name(),
+		Interface:        r.network.iface,
+		IPFamily:         r.network.family.label(),
+		Proxy:            r.network.proxyFor(server),
+		Client:           r.client,
+		ISPChanged:       r.clientChanged,
+		Server:           server.Info(),
+		MeasurementIndex: i + 1,
+		Repetition:       planned.repetition,
+		ServerReused:     planned.reused,
+	}
+
+	// A server without an address in the family is a configuration problem, not a server failure
+	if err := r.network.checkServer(measurementCtx, server); err != nil {
+		measurementSpan.RecordError(err)
+		measurementSpan.SetStatus(codes.Error, "server unreachable over IP family")
+		return nil, fmt.Errorf("measurement %d failed: %w", i+1, err)
+	}
+
+	// The ranking latency stands in until a latency phase measures it
+	result.Latency = server.Latency
+	result.Jitter = server.Jitter
+
+	// Run the enabled phases in the configured order
+	for _, phase := range r.config.Phases {
+		if !phase.Enabled {
+			continue
+		}
+		if err := r.runPhase(measurementCtx, phase, server, result); err != nil {
+			r.quarantine.RecordFailure(r.backend.Name(), result.Server, err.Error(), time.Now())
+			measurementSpan.RecordError(err)
+			measurementSpan.SetStatus(codes.Error, fmt.Sprintf("%s test failed", phase.Type))
+			return nil, fmt.Errorf("%s test failed for measurement %d: %w", phase.Type, i+1, err)
+		}
+	}
+
+	result.Duration = time.Since(startTime)
+	measurementSpan.SetAttributes(r.phaseSpanAttributes(result)...)
+
+	// Track anomalous results so misbehaving servers end up in quarantine
+	if reason := r.anomaly(result); reason != "" {
+		fmt.Fprintf(os.Stderr, "Warning: Anomalous result from server %s: %s\n", server.ID, reason)
+		r.quarantine.RecordFailure(r.backend.Name(), result.Server, reason, time.Now())
+		measurementSpan.SetAttributes(attribute.String("speedtest.anomaly", reason))
+	} else {
+		r.quarantine.RecordSuccess(r.backend.Name(), server.ID, time.Now())
+	}
+
+	// Trace the route while the degradation is likely to persist, next to light phases only
+	unlock := r.lockPhase(PhaseLatency)
+	r.runPathTrace(measurementCtx, server, result)
+	unlock()
+
+	measurementSpan.SetStatus(codes.Ok, "measurement completed successfully")
+
+	return result, nil
+}
+
+// anomaly returns a description of what is wrong with the result, or an empty string if it looks plausible
+func (r *Runner) anomaly(result *Result) string {
+	if r.config.PhaseEnabled(PhaseDownload) && result.DownloadMbps <= 0 {
+		return "no download throughput reported"
+	}
+	if r.config.PhaseEnabled(PhaseUpload) && result.UploadMbps <= 0 {
+		return "no upload throughput reported"
+	}
+
+	if r.config.QuarantineMaxMbps > 0 {
+		if result.DownloadMbps > r.config.QuarantineMaxMbps {
+			return fmt.Sprintf("implausible download of %.2f Mbps", result.DownloadMbps)
+		}
+		if result.UploadMbps > r.config.QuarantineMaxMbps {
+			return fmt.Sprintf("implausible upload of %.2f Mbps", result.UploadMbps)
+		}
+	}
+
+	return ""
+}
+
+// filterServers removes excluded and quarantined servers from automatic selection
+func (r *Runner) filterServers(servers []*Server) ([]*Server, int, int) {
+	now := time.Now()
+	filtered := make([]*Server, 0, len(servers))
+	excluded, quarantined := 0, 0
+
+	for _, server := range servers {
+		switch {
+		case slices.Contains(r.config.ExcludeServerIDs, server.ID):
+			excluded++
+		case r.quarantine.IsQuarantined(r.backend.Name(), server.ID, now):
+			quarantined++
+		default:
+			filtered = append(filtered, server)
+		}
+	}
 
-	// Fetch server list
-	serverList, err := user.FetchServers()
+	return filtered, excluded, quarantined
+}
+
+func (r *Runner) selectServers(ctx context.Context) ([]*Server, error) {
+	ctx, span := tracer.Start(ctx, "speedtest.server_selection")
+	defer span.End()
+
+	// Fetch server list, served from the cache while it is fresh
+	serverList, err := r.fetchServers(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch servers: %w", err)
 	}
 
-	var targets []*speedtest.Server
+	var targets []*Server
+
+	// Automatic candidates, minus the ones excluded by configuration or quarantined
+	candidates, excluded, quarantined := r.filterServers(serverList)
+	span.SetAttributes(
+		attribute.Int("excluded_count", excluded),
+		attribute.Int("quarantined_count", quarantined),
+	)
 
 	// If specific server IDs are provided, use them
 	if len(r.config.ServerIDs) > 0 {
-		preferredServers := make([]int, 0, len(r.config.ServerIDs))
-		for _, idStr := range r.config.ServerIDs {
-			if id, err := strconv.Atoi(idStr); err == nil {
-				preferredServers = append(preferredServers, id)
-			} else {
-				return nil, fmt.Errorf("invalid server ID '%s': %w", idStr, err)
+		for _, id := range r.config.ServerIDs {
+			index := slices.IndexFunc(serverList, func(server *Server) bool { return server.ID == id })
+			if index < 0 {
+				fmt.Fprintf(os.Stderr, "Warning: Server ID %s not found in the server list, skipping it\n", id)
+				continue
 			}
+			targets = append(targets, serverList[index])
 		}
-		targets, err = serverList.FindServer(preferredServers)
-		if err != nil {
-			return nil, fmt.Errorf("failed to find servers with IDs %v: %w", r.config.ServerIDs, err)
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("none of the servers with IDs %v found", r.config.ServerIDs)
 		}
+
+		// Measure the latency of the requested servers, keeping their order
+		r.pingServers(ctx, targets)
 	} else {
-		// No specific server IDs, use serverList directly
-		// serverList is already the list of all available servers
-		targets = serverList
-
-		// Sort servers by latency (lowest first) for multi-server mode
-		if r.config.MeasurementStrategy == MeasurementStrategyMultiServer {
-			sort.Slice(targets, func(i, j int) bool {
-				return targets[i].Latency < targets[j].Latency
-			})
-		}
+		// No specific server IDs, rank the nearest automatic candidates
+		// by their median latency (lowest first)
+		targets = r.rankServers(ctx, candidates)
 	}
 
 	if len(targets) == 0 {
@@ -300,14 +968,14 @@ func (r *Runner) selectServers(ctx context.Context) ([]*speedtest.Server, error)
 	}
 
 	// Select servers based on strategy
-	var selectedServers []*speedtest.Server
+	var selectedServers []*Server
 
 	switch r.config.MeasurementStrategy {
 	case MeasurementStrategySingleServer:
 		// Use the same server for all measurements (best latency)
-		selectedServers = []*speedtest.Server{targets[0]}
+		selectedServers = []*Server{targets[0]}
 
-	case MeasurementStrategyMultiServer:
+	case MeasurementStrategyMultiServer, MeasurementStrategyMatrix:
 		// Use different servers for each measurement
 		// If specific server IDs were provided, use all of them
 		if len(r.config.ServerIDs) > 0 {
@@ -322,8 +990,44 @@ func (r *Runner) selectServers(ctx context.Context) ([]*speedtest.Server, error)
 			selectedServers = targets[:count]
 		}
 
+	case MeasurementStrategyRoundRobin:
+		// Continue the rotation where the previous run of the backend on this network left off
+		rotationKey := r.backend.Name() + "@" + r.network.key()
+		selectedServers, err = pickRoundRobin(r.serverPool(targets), r.config.MeasurementCount, r.config.StateDir, rotationKey)
+		if err != nil {
+			return nil, fmt.Errorf("round-robin rotation failed: %w", err)
+		}
+
+	case MeasurementStrategyRandom:
+		selectedServers = pickWeightedRandom(r.serverPool(targets), r.config.MeasurementCount)
+
+	case MeasurementStrategyFixedThenRandom:
+		// The first server ID (or the best latency server) is fixed, the remaining
+		// server IDs form the random pool. With a single server ID, the random
+		// pool is built from the automatic candidates instead.
+		pool := r.serverPool(targets)
+		fixed := pool[0]
+		randomPool := pool[1:]
+		if len(r.config.ServerIDs) == 1 {
+			randomPool = slices.DeleteFunc(r.serverPool(r.rankServers(ctx, candidates)), func(server *Server) bool {
+				return server.ID == fixed.ID
+			})
+		}
+		if len(randomPool) == 0 {
+			randomPool = []*Server{fixed}
+		}
+
+		selectedServers = []*Server{fixed}
+		if r.config.MeasurementCount > 1 {
+			selectedServers = append(selectedServers, pickWeightedRandom(randomPool, r.config.MeasurementCount-1)...)
+		}
+
 	default:
-		selectedServers = []*speedtest.Server{targets[0]}
+		selectedServers = []*Server{targets[0]}
+	}
+
+	if len(selectedServers) == 0 {
+		return nil, fmt.Errorf("no reachable servers found")
 	}
 
 	span.SetAttributes(
@@ -334,64 +1038,136 @@ func (r *Runner) selectServers(ctx context.Context) ([]*speedtest.Server, error)
 	return selectedServers, nil
 }
 
-func (r *Runner) runDownloadTest(ctx context.Context, server *speedtest.Server) (float64, error) {
+// serverPool returns the servers the pool-based strategies pick from.
+// Explicitly requested servers form the pool as-is, otherwise it consists of
+// the ranked servers with the lowest latency, limited to ServerPoolSize.
+func (r *Runner) serverPool(targets []*Server) []*Server {
+	if len(r.config.ServerIDs) > 0 || len(targets) <= r.config.ServerPoolSize {
+		return targets
+	}
+
+	return targets[:r.config.ServerPoolSize]
+}
+
+func (r *Runner) runDownloadTest(ctx context.Context, phase Phase, server *Server, result *Result) error {
 	ctx, span := tracer.Start(ctx, "speedtest.download_test")
 	defer span.End()
 
 	if server == nil {
-		return 0, fmt.Errorf("server missing")
+		return fmt.Errorf("server missing")
 	}
 
+	// Streams and duration as requested, zero means the backend default
+	opts := r.transferOptions(phase)
 	span.SetAttributes(
 		attribute.String("server.id", server.ID),
 		attribute.String("server.name", server.Name),
+		attribute.Int("download.streams", opts.Streams),
+		attribute.Int64("download.target_duration_nanos", opts.Duration.Nanoseconds()),
 	)
 
-	err := server.DownloadTest()
+	transfer, err := r.backend.Download(ctx, server, opts)
 	if err != nil {
-		return 0, fmt.Errorf("download test failed: %w", err)
+		return fmt.Errorf("download test failed: %w", err)
 	}
 
-	mbps := server.DLSpeed.Mbps()
-	span.SetAttributes(attribute.Float64("download.mbps", mbps))
+	span.SetAttributes(
+		attribute.Float64("download.mbps", transfer.Mbps),
+		attribute.Int64("download.bytes", transfer.Bytes),
+		attribute.Int64("download.duration_nanos", transfer.Duration.Nanoseconds()),
+	)
+	if transfer.TTFB > 0 {
+		span.SetAttributes(attribute.Int64("download.ttfb_nanos", transfer.TTFB.Nanoseconds()))
+	}
+	if transfer.LoadedLatency > 0 {
+		span.SetAttributes(attribute.Int64("download.loaded_latency_nanos", transfer.LoadedLatency.Nanoseconds()))
+	}
 
-	latency := server.Latency.Nanoseconds()
+	latency := result.Latency.Nanoseconds()
 	span.SetAttributes(attribute.Int64("download.latency_nanos", latency))
 
-	jitter := server.Jitter.Nanoseconds()
+	jitter := result.Jitter.Nanoseconds()
 	span.SetAttributes(attribute.Int64("download.jitter_nanos", jitter))
 
-	return mbps, nil
+	result.DownloadMbps = transfer.Mbps
+	result.DownloadTTFB = transfer.TTFB
+	result.DownloadDuration = transfer.Duration
+	if transfer.LoadedLatency > 0 {
+		result.DownloadLoadedLatency = transfer.LoadedLatency
+	}
+
+	// A failed comparison leaves the multi-stream result intact
+	if r.config.StreamComparison {
+		single, err := r.runSingleStreamTest(ctx, PhaseDownload, opts, server)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			result.DownloadSingleStreamMbps = single.Mbps
+			span.SetAttributes(attribute.Float64("download.single_stream_mbps", single.Mbps))
+		}
+	}
+
+	return nil
 }
 
-func (r *Runner) runUploadTest(ctx context.Context, server *speedtest.Server) (float64, error) {
+func (r *Runner) runUploadTest(ctx context.Context, phase Phase, server *Server, result *Result) error {
 	ctx, span := tracer.Start(ctx, "speedtest.upload_test")
 	defer span.End()
 
 	if server == nil {
-		return 0, fmt.Errorf("server missing")
+		return fmt.Errorf("server missing")
 	}
 
+	// Streams and duration as requested, zero means the backend default
+	opts := r.transferOptions(phase)
 	span.SetAttributes(
 		attribute.String("server.id", server.ID),
 		attribute.String("server.name", server.Name),
+		attribute.Int("upload.streams", opts.Streams),
+		attribute.Int64("upload.target_duration_nanos", opts.Duration.Nanoseconds()),
 	)
 
-	err := server.UploadTest()
+	transfer, err := r.backend.Upload(ctx, server, opts)
 	if err != nil {
-		return 0, fmt.Errorf("upload test failed: %w", err)
+		return fmt.Errorf("upload test failed: %w", err)
 	}
 
-	mbps := server.ULSpeed.Mbps()
-	span.SetAttributes(attribute.Float64("upload.mbps", mbps))
+	span.SetAttributes(
+		attribute.Float64("upload.mbps", transfer.Mbps),
+		attribute.Int64("upload.bytes", transfer.Bytes),
+		attribute.Int64("upload.duration_nanos", transfer.Duration.Nanoseconds()),
+	)
+	if transfer.TTFB > 0 {
+		span.SetAttributes(attribute.Int64("upload.ttfb_nanos", transfer.TTFB.Nanoseconds()))
+	}
+	if transfer.LoadedLatency > 0 {
+		span.SetAttributes(attribute.Int64("upload.loaded_latency_nanos", transfer.LoadedLatency.Nanoseconds()))
+	}
 
-	latency := server.Latency.Nanoseconds()
+	latency := result.Latency.Nanoseconds()
 	span.SetAttributes(attribute.Int64("upload.latency_nanos", latency))
 
-	jitter := server.Jitter.Nanoseconds()
+	jitter := result.Jitter.Nanoseconds()
 	span.SetAttributes(attribute.Int64("upload.jitter_nanos", jitter))
 
-	return mbps, nil
+	result.UploadMbps = transfer.Mbps
+	result.UploadDuration = transfer.Duration
+	if transfer.LoadedLatency > 0 {
+		result.UploadLoadedLatency = transfer.LoadedLatency
+	}
+
+	// A failed comparison leaves the multi-stream result intact
+	if r.config.StreamComparison {
+		single, err := r.runSingleStreamTest(ctx, PhaseUpload, opts, server)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			result.UploadSingleStreamMbps = single.Mbps
+			span.SetAttributes(attribute.Float64("upload.single_stream_mbps", single.Mbps))
+		}
+	}
+
+	return nil
 }
 
 // Helper functions for environment variables
@@ -411,6 +1187,15 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if d, err := time.ParseDuration(value); err == nil {
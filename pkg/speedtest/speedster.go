@@ -0,0 +1,152 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	speedsterDefaultDuration = 10 * time.Second
+	speedsterDefaultStreams  = 4
+)
+
+// speedsterBackend measures against speedster test servers started with `speedster server`
+type speedsterBackend struct {
+	baseURLs []string
+	client   *http.Client
+	duration time.Duration
+	streams  int
+}
+
+func newSpeedsterBackend(config Config, nw network) *speedsterBackend {
+	return &speedsterBackend{
+		baseURLs: config.SpeedsterServers,
+		client:   nw.httpClient(),
+		duration: speedsterDefaultDuration,
+		streams:  speedsterDefaultStreams,
+	}
+}
+
+func (b *speedsterBackend) Name() string {
+	return string(BackendSpeedster)
+}
+
+// Servers returns the configured speedster servers, identified by their base URL
+func (b *speedsterBackend) Servers(_ context.Context) ([]*Server, error) {
+	servers := make([]*Server, 0, len(b.baseURLs))
+	for _, baseURL := range b.baseURLs {
+		baseURL = strings.TrimSuffix(baseURL, "/")
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid speedster server URL '%s'", baseURL)
+		}
+
+		servers = append(servers, &Server{
+			ID:   baseURL,
+			Name: u.Hostname(),
+			Host: u.Host,
+			URL:  baseURL,
+		})
+	}
+
+	return servers, nil
+}
+
+// Ping measures the HTTP round trip time of the latency echo endpoint.
+// A first request sets up the connection and is not counted.
+func (b *speedsterBackend) Ping(ctx context.Context, server *Server, count int) ([]time.Duration, error) {
+	if _, err := b.ping(ctx, server); err != nil {
+		return nil, err
+	}
+
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		latency, err := b.ping(ctx, server)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, latency)
+	}
+
+	return samples, nil
+}
+
+func (b *speedsterBackend) ping(ctx context.Context, server *Server) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ping", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return 0, fmt.Errorf("ping failed with status %s", resp.Status)
+	}
+
+	return latency, nil
+}
+
+func (b *speedsterBackend) Download(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	downloadURL := server.URL + "/download?size=" + strconv.Itoa(httpChunkSize)
+
+	opts = opts.withDefaults(b.duration, b.streams)
+	return measureHTTP(ctx, opts.Streams, opts.Duration, func(ctx context.Context, progress *httpProgress) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("download failed with status %s", resp.Status)
+		}
+		progress.gotFirstByte()
+
+		return drainBody(resp.Body, &progress.bytes)
+	})
+}
+
+func (b *speedsterBackend) Upload(ctx context.Context, server *Server, opts TransferOptions) (Transfer, error) {
+	uploadURL := server.URL + "/upload"
+
+	opts = opts.withDefaults(b.duration, b.streams)
+	return measureHTTP(ctx, opts.Streams, opts.Duration, func(ctx context.Context, progress *httpProgress) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, newPayloadReader(httpChunkSize, &progress.bytes))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = httpChunkSize
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("upload failed with status %s", resp.Status)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+package speedtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thiemok/speedster/pkg/server"
+)
+
+// speedsterStandIn runs the speedster test server on a local port and counts
+// the requests per endpoint
+type speedsterStandIn struct {
+	server    *httptest.Server
+	pings     atomic.Int64
+	downloads atomic.Int64
+	uploads   atomic.Int64
+}
+
+// newSpeedsterStandIn starts the test server, stopped with the test
+func newSpeedsterStandIn(t *testing.T) *speedsterStandIn {
+	t.Helper()
+
+	testServer, err := server.New(server.Config{})
+	if err != nil {
+		t.Fatalf("failed to create test server: %v", err)
+	}
+	handler := testServer.Handler()
+
+	s := &speedsterStandIn{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			s.pings.Add(1)
+		case "/download":
+			s.downloads.Add(1)
+		case "/upload":
+			s.uploads.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+// testSpeedsterServer returns the stand-in as server of a speedster backend
+func testSpeedsterServer(t *testing.T, s *speedsterStandIn) (*speedsterBackend, *Server) {
+	t.Helper()
+
+	// A trailing slash is trimmed from the base URL
+	b := newSpeedsterBackend(Config{SpeedsterServers: []string{s.server.URL + "/"}}, network{family: IPFamilyAuto})
+	servers, err := b.Servers(context.Background())
+	if err != nil {
+		t.Fatalf("Servers failed: %v", err)
+	}
+	if len(servers) != 1 || servers[0].ID != s.server.URL {
+		t.Fatalf("got servers %+v, want the stand-in identified by its base URL", servers)
+	}
+
+	return b, servers[0]
+}
+
+func TestSpeedsterPing(t *testing.T) {
+	standIn := newSpeedsterStandIn(t)
+	b, srv := testSpeedsterServer(t, standIn)
+
+	samples, err := b.Ping(context.Background(), srv, 3)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	for _, sample := range samples {
+		if sample <= 0 {
+			t.Errorf("got non-positive sample %v", sample)
+		}
+	}
+
+	// The first request sets up the connection and is not counted
+	if got := standIn.pings.Load(); got != 4 {
+		t.Errorf("got %d ping requests, want 4", got)
+	}
+}
+
+func TestSpeedsterDownload(t *testing.T) {
+	standIn := newSpeedsterStandIn(t)
+	b, srv := testSpeedsterServer(t, standIn)
+
+	transfer, err := b.Download(context.Background(), srv, TransferOptions{Duration: time.Second, Streams: 2})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	if transfer.Mbps <= 0 || transfer.Bytes <= 0 {
+		t.Errorf("got %.2f Mbps over %d bytes, want a positive throughput", transfer.Mbps, transfer.Bytes)
+	}
+	if transfer.TTFB <= 0 || transfer.TTFB > transfer.Duration {
+		t.Errorf("got TTFB %v of %v, want the time to the first byte", transfer.TTFB, transfer.Duration)
+	}
+	if got := standIn.downloads.Load(); got < 2 {
+		t.Errorf("got %d download requests, want at least one per stream", got)
+	}
+}
+
+func TestSpeedsterUpload(t *testing.T) {
+	standIn := newSpeedsterStandIn(t)
+	b, srv := testSpeedsterServer(t, standIn)
+
+	transfer, err := b.Upload(context.Background(), srv, TransferOptions{Duration: time.Second, Streams: 2})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	if transfer.Mbps <= 0 || transfer.Bytes <= 0 {
+		t.Errorf("got %.2f Mbps over %d bytes, want a positive throughput", transfer.Mbps, transfer.Bytes)
+	}
+	if got := standIn.uploads.Load(); got < 2 {
+		t.Errorf("got %d upload requests, want at least one per stream", got)
+	}
+}
+
+func TestSpeedsterPingFailure(t *testing.T) {
+	standIn := newSpeedsterStandIn(t)
+	b := newSpeedsterBackend(Config{}, network{family: IPFamilyAuto})
+
+	// Only the test endpoints exist, anything else is an error status
+	_, err := b.Ping(context.Background(), &Server{ID: "missing", URL: standIn.server.URL + "/missing"}, 1)
+	if err == nil {
+		t.Fatal("got no error for a missing ping endpoint")
+	}
+}
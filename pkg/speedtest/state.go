@@ -0,0 +1,60 @@
+package speedtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ReadState decodes the named state file from stateDir into v.
+// A missing file is not an error and leaves v untouched.
+func ReadState(stateDir, name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(stateDir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read state file %s: %w", name, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode state file %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// WriteState atomically encodes v into the named state file in stateDir
+func WriteState(stateDir, name string, v any) error {
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state file %s: %w", name, err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state file behind
+	tmp, err := os.CreateTemp(stateDir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file %s: %w", name, err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(stateDir, name)); err != nil {
+		return fmt.Errorf("failed to replace state file %s: %w", name, err)
+	}
+
+	return nil
+}
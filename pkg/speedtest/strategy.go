@@ -0,0 +1,94 @@
+package speedtest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+const rotationStateFile = "rotation.json"
+
+// rotationState remembers where round-robin rotation left off, per backend and network
+type rotationState struct {
+	LastServerIDs map[string]string `json:"last_server_ids"`
+}
+
+// pickRoundRobin selects count servers from the pool, continuing after the
+// server used last time by the rotation with the key. The position is tracked
+// by server ID so the rotation stays stable when the pool changes between runs.
+func pickRoundRobin(pool []*Server, count int, stateDir, key string) ([]*Server, error) {
+	var state rotationState
+	if stateDir != "" {
+		if err := ReadState(stateDir, rotationStateFile, &state); err != nil {
+			return nil, err
+		}
+	}
+	if state.LastServerIDs == nil {
+		state.LastServerIDs = make(map[string]string)
+	}
+
+	// Each server at most once per run
+	if count > len(pool) {
+		fmt.Fprintf(os.Stderr, "Warning: Requested %d measurements but only %d servers in the rotation\n", count, len(pool))
+		count = len(pool)
+	}
+
+	start := 0
+	for i, server := range pool {
+		if server.ID == state.LastServerIDs[key] {
+			start = i + 1
+			break
+		}
+	}
+
+	selected := make([]*Server, 0, count)
+	for i := 0; i < count; i++ {
+		selected = append(selected, pool[(start+i)%len(pool)])
+	}
+
+	if stateDir != "" {
+		state.LastServerIDs[key] = selected[len(selected)-1].ID
+		if err := WriteState(stateDir, rotationStateFile, state); err != nil {
+			return nil, err
+		}
+	}
+
+	return selected, nil
+}
+
+// pickWeightedRandom selects count servers from the pool at random, favouring
+// servers with lower latency. Servers are not repeated until the pool is exhausted.
+func pickWeightedRandom(pool []*Server, count int) []*Server {
+	selected := make([]*Server, 0, count)
+	remaining := make([]*Server, 0, len(pool))
+
+	for len(selected) < count {
+		if len(remaining) == 0 {
+			remaining = append(remaining, pool...)
+		}
+
+		// Weight each server by its inverse latency
+		weights := make([]float64, len(remaining))
+		var total float64
+		for i, server := range remaining {
+			weights[i] = 1 / float64(max(server.Latency, time.Millisecond))
+			total += weights[i]
+		}
+
+		pick := rand.Float64() * total
+		index := len(remaining) - 1
+		for i, weight := range weights {
+			if pick < weight {
+				index = i
+				break
+			}
+			pick -= weight
+		}
+
+		selected = append(selected, remaining[index])
+		remaining = append(remaining[:index], remaining[index+1:]...)
+	}
+
+	return selected
+}
@@ -0,0 +1,248 @@
+package speedtest
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Verdict is the outcome of evaluating results against the thresholds
+type Verdict string
+
+const (
+	// VerdictPass means all thresholds were met
+	VerdictPass Verdict = "pass"
+
+	// VerdictWarn means a warning threshold was missed, but no failure threshold
+	VerdictWarn Verdict = "warn"
+
+	// VerdictFail means a failure threshold was missed
+	VerdictFail Verdict = "fail"
+)
+
+// Severity orders the verdicts, 0 for pass up to 2 for fail
+func (v Verdict) Severity() int {
+	switch v {
+	case VerdictWarn:
+		return 1
+	case VerdictFail:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// ThresholdMetric is a result value thresholds apply to
+type ThresholdMetric string
+
+const (
+	// ThresholdDownload is the download throughput in Mbps, a minimum
+	ThresholdDownload ThresholdMetric = "download"
+
+	// ThresholdUpload is the upload throughput in Mbps, a minimum
+	ThresholdUpload ThresholdMetric = "upload"
+
+	// ThresholdLatency is the idle latency in milliseconds, a maximum
+	ThresholdLatency ThresholdMetric = "latency"
+
+	// ThresholdJitter is the jitter in milliseconds, a maximum
+	ThresholdJitter ThresholdMetric = "jitter"
+
+	// ThresholdPacketLoss is the packet loss in percent, a maximum
+	ThresholdPacketLoss ThresholdMetric = "packet_loss"
+)
+
+// minimum reports whether values below the threshold breach it
+func (m ThresholdMetric) minimum() bool {
+	return m == ThresholdDownload || m == ThresholdUpload
+}
+
+// Format formats a value of the metric with its unit
+func (m ThresholdMetric) Format(value float64) string {
+	switch m {
+	case ThresholdDownload, ThresholdUpload:
+		return fmt.Sprintf("%.2f Mbps", value)
+	case ThresholdLatency, ThresholdJitter:
+		return (time.Duration(value * float64(time.Millisecond))).Round(time.Microsecond).String()
+	default:
+		return fmt.Sprintf("%.2f%%", value)
+	}
+}
+
+// Threshold holds the warning and failure limits of a metric, 0 disables a limit
+type Threshold struct {
+	Metric ThresholdMetric
+	Warn   float64
+	Fail   float64
+}
+
+// verdict returns how the value compares to the limits, and the limit it missed
+func (t Threshold) verdict(value float64) (Verdict, float64) {
+	missed := func(limit float64) bool {
+		if limit == 0 {
+			return false
+		}
+		if t.Metric.minimum() {
+			return value < limit
+		}
+		return value > limit
+	}
+
+	switch {
+	case missed(t.Fail):
+		return VerdictFail, t.Fail
+	case missed(t.Warn):
+		return VerdictWarn, t.Warn
+	case t.Fail != 0:
+		return VerdictPass, t.Fail
+	default:
+		return VerdictPass, t.Warn
+	}
+}
+
+// ThresholdCheck is the comparison of one metric against its threshold
+type ThresholdCheck struct {
+	Metric  ThresholdMetric
+	Verdict Verdict
+	Value   float64
+	// Limit is the limit that was missed, the failure limit if none was
+	Limit float64
+}
+
+// String describes the check, e.g. "download 42.00 Mbps below 50.00 Mbps (fail)"
+func (c ThresholdCheck) String() string {
+	comparison := "above"
+	if c.Metric.minimum() {
+		comparison = "below"
+	}
+	if c.Verdict == VerdictPass {
+		comparison = "within"
+	}
+
+	return fmt.Sprintf("%s %s %s %s (%s)", c.Metric, c.Metric.Format(c.Value), comparison, c.Metric.Format(c.Limit), c.Verdict)
+}
+
+// Evaluation is the verdict of a measurement, or of all measurements of a run
+type Evaluation struct {
+	Verdict Verdict
+	Checks  []ThresholdCheck
+}
+
+// Breaches returns the checks that missed a threshold
+func (e Evaluation) Breaches() []ThresholdCheck {
+	var breaches []ThresholdCheck
+	for _, check := range e.Checks {
+		if check.Verdict != VerdictPass {
+			breaches = append(breaches, check)
+		}
+	}
+
+	return breaches
+}
+
+// thresholdEnv maps the metrics to the environment variables of their failure
+// limit, the warning limit has the same name with a _WARN suffix
+var thresholdEnv = []struct {
+	metric   ThresholdMetric
+	key      string
+	duration bool
+}{
+	{ThresholdDownload, "SPEEDTEST_THRESHOLD_MIN_DOWNLOAD_MBPS", false},
+	{ThresholdUpload, "SPEEDTEST_THRESHOLD_MIN_UPLOAD_MBPS", false},
+	{ThresholdLatency, "SPEEDTEST_THRESHOLD_MAX_LATENCY", true},
+	{ThresholdJitter, "SPEEDTEST_THRESHOLD_MAX_JITTER", true},
+	{ThresholdPacketLoss, "SPEEDTEST_THRESHOLD_MAX_PACKET_LOSS", false},
+}
+
+// loadThresholds loads the thresholds from environment variables. Latency and
+// jitter are durations, stored in milliseconds like the other limits' units.
+func loadThresholds() ([]Threshold, error) {
+	var thresholds []Threshold
+	for _, env := range thresholdEnv {
+		threshold := Threshold{Metric: env.metric}
+		if env.duration {
+			threshold.Fail = float64(getEnvDuration(env.key, 0)) / float64(time.Millisecond)
+			threshold.Warn = float64(getEnvDuration(env.key+"_WARN", 0)) / float64(time.Millisecond)
+		} else {
+			threshold.Fail = getEnvFloat(env.key, 0)
+			threshold.Warn = getEnvFloat(env.key+"_WARN", 0)
+		}
+		if threshold.Fail < 0 || threshold.Warn < 0 {
+			return nil, fmt.Errorf("%s must not be negative", env.key)
+		}
+		if threshold.Fail == 0 && threshold.Warn == 0 {
+			continue
+		}
+
+		// The warning limit has to be reached before the failure limit
+		if threshold.Fail != 0 && threshold.Warn != 0 {
+			if verdict, _ := threshold.verdict(threshold.Warn); verdict == VerdictFail {
+				return nil, fmt.Errorf("%s_WARN must be less strict than %s", env.key, env.key)
+			}
+		}
+		thresholds = append(thresholds, threshold)
+	}
+
+	if len(thresholds) > 0 && !slices.ContainsFunc(thresholds, func(t Threshold) bool { return t.Fail != 0 }) {
+		fmt.Fprintf(os.Stderr, "Warning: Only warning thresholds are set, the verdict never fails\n")
+	}
+
+	return thresholds, nil
+}
+
+// thresholdValue returns the value of the metric in the result, false if the
+// result did not measure it
+func (c Config) thresholdValue(metric ThresholdMetric, result *Result) (float64, bool) {
+	switch metric {
+	case ThresholdDownload:
+		return result.DownloadMbps, c.PhaseEnabled(PhaseDownload)
+	case ThresholdUpload:
+		return result.UploadMbps, c.PhaseEnabled(PhaseUpload)
+	case ThresholdLatency:
+		return float64(result.Latency) / float64(time.Millisecond), result.Latency > 0
+	case ThresholdJitter:
+		return float64(result.Jitter) / float64(time.Millisecond), result.Latency > 0
+	default:
+		return result.PacketLoss, result.PacketLossMeasured
+	}
+}
+
+// Evaluate checks every result against the thresholds, storing the verdict on
+// the result, and returns the overall verdict of the run. The overall verdict
+// checks the median of every metric, so a single bad server does not fail a
+// run that otherwise met the thresholds.
+func (c Config) Evaluate(results []*Result) Evaluation {
+	overall := Evaluation{Verdict: VerdictPass}
+	for _, result := range results {
+		result.Evaluation = Evaluation{Verdict: VerdictPass}
+	}
+
+	for _, threshold := range c.Thresholds {
+		var values []float64
+		for _, result := range results {
+			value, ok := c.thresholdValue(threshold.Metric, result)
+			if !ok {
+				continue
+			}
+			values = append(values, value)
+			result.Evaluation.add(threshold, value)
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		overall.add(threshold, median(values))
+	}
+
+	return overall
+}
+
+// add checks the value against the threshold, raising the verdict if it missed it
+func (e *Evaluation) add(threshold Threshold, value float64) {
+	verdict, limit := threshold.verdict(value)
+	e.Checks = append(e.Checks, ThresholdCheck{Metric: threshold.Metric, Verdict: verdict, Value: value, Limit: limit})
+	if verdict.Severity() > e.Verdict.Severity() {
+		e.Verdict = verdict
+	}
+}